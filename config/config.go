@@ -1,6 +1,8 @@
 package config
 
 import (
+	"fmt"
+
 	"github.com/go-playground/validator/v10"
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/pflag"
@@ -11,8 +13,13 @@ func New() (*Root, error) {
 	_ = pflag.String("config", "", "path to config file")
 	viper.BindPFlag("config", pflag.Lookup("config"))
 	pflag.Parse()
-	if v := viper.GetString("config"); v != "" {
-		viper.SetConfigFile(v)
+	return Load(viper.GetString("config"))
+}
+
+// Load reads and validates the config file at configPath, or the default config file if configPath is empty.
+func Load(configPath string) (*Root, error) {
+	if configPath != "" {
+		viper.SetConfigFile(configPath)
 	}
 
 	if err := viper.ReadInConfig(); err != nil {
@@ -27,5 +34,61 @@ func New() (*Root, error) {
 		return nil, err
 	}
 
-	return conf, validator.New().Struct(conf)
+	validate := validator.New()
+	validate.RegisterStructValidation(validateStorage, Storage{})
+	validate.RegisterStructValidation(validateServer, Server{})
+	return conf, validate.Struct(conf)
+}
+
+// validateServer ensures that admin paths are only enabled with a token to authorize requests,
+// as they are served on the same listener as the webhook.
+func validateServer(sl validator.StructLevel) {
+	server := sl.Current().Interface().(Server)
+	if (server.Paths.Pin != "" || server.Paths.CleanPlan != "") && server.AdminToken == "" {
+		sl.ReportError(server.AdminToken, "AdminToken", "AdminToken", "required_with", "Paths.Pin Paths.CleanPlan")
+	}
+}
+
+// validateStorage ensures that exactly one backend is configured for each upload destination,
+// and that names of destinations are unique.
+// Local mirror in move mode removes the source file, so it should be the only destination without action after upload;
+// AfterUpload "move" should be used instead otherwise.
+// Action after upload removes the source file as well, so all required destinations should verify uploads by checksum.
+func validateStorage(sl validator.StructLevel) {
+	storage := sl.Current().Interface().(Storage)
+	movesSource := storage.AfterUpload != "" && storage.AfterUpload != "keep"
+	if len(storage.Destinations) == 0 {
+		if storage.Backend.Configured() != 1 {
+			sl.ReportError(storage.Backend, "Backend", "Backend", "exactly_one_backend", "")
+		}
+		if storage.Backend.movesSource() && movesSource {
+			sl.ReportError(storage.LocalMirror.Mode, "LocalMirror.Mode", "Mode", "excluded_with", "AfterUpload")
+		}
+		if movesSource && !storage.Backend.verifiesChecksum() {
+			sl.ReportError(storage.AfterUpload, "AfterUpload", "AfterUpload", "verified_checksum", "Backend")
+		}
+		return
+	}
+
+	if storage.Backend.Configured() != 0 {
+		sl.ReportError(storage.Backend, "Backend", "Backend", "excluded_with", "Destinations")
+	}
+	names := make(map[string]struct{}, len(storage.Destinations))
+	for i, destination := range storage.Destinations {
+		field := fmt.Sprintf("Destinations[%d]", i)
+		if destination.Backend.Configured() != 1 {
+			sl.ReportError(destination.Backend, field+".Backend", "Backend", "exactly_one_backend", "")
+		}
+		if destination.Backend.movesSource() && (len(storage.Destinations) > 1 || movesSource) {
+			sl.ReportError(destination.LocalMirror.Mode, field+".LocalMirror.Mode", "Mode", "excluded_with",
+				"Destinations AfterUpload")
+		}
+		if movesSource && !destination.Optional && !destination.Backend.verifiesChecksum() {
+			sl.ReportError(storage.AfterUpload, "AfterUpload", "AfterUpload", "verified_checksum", field+".Backend")
+		}
+		if _, ok := names[destination.Name]; ok {
+			sl.ReportError(destination.Name, field+".Name", "Name", "unique", "")
+		}
+		names[destination.Name] = struct{}{}
+	}
 }
@@ -5,21 +5,42 @@ import "time"
 type Root struct {
 	Server   Server          `mapstructure:"server" validate:"required"`
 	Services ServiceRegistry `mapstructure:"services" validate:"required"`
+	State    State           `mapstructure:"state" validate:"required"`
+	Workers  Workers         `mapstructure:"workers"`
+	// DryRun only logs recordings which would be removed, instead of removing them, for all local drives and backends.
+	DryRun bool `mapstructure:"dryRun"`
 }
 
 type Server struct {
 	ListenAddress string        `mapstructure:"listenAddress" validate:"required"`
 	Timeout       time.Duration `mapstructure:"timeout" validate:"required,gt=0"`
 	Paths         HandlerPaths  `mapstructure:"paths" validate:"required"`
+	// EventTTL is how long IDs of events handled are remembered, to ignore events redelivered by the recorder.
+	// Default of 24 hours is used if not configured.
+	EventTTL time.Duration `mapstructure:"eventTTL" validate:"gte=0"`
+	// AdminToken is the bearer token required by admin paths, and sent by admin subcommands;
+	// it is required if any admin path is configured.
+	AdminToken string `mapstructure:"adminToken"`
+}
+
+type State struct {
+	Directory string `mapstructure:"directory" validate:"required"`
+	// FinishedTTL is how long finished upload jobs and archives of uploaded recordings are kept before pruned.
+	// Default of 7 days is used if not configured.
+	FinishedTTL time.Duration `mapstructure:"finishedTTL" validate:"gte=0"`
 }
 
 type HandlerPaths struct {
 	RecordUpload string `mapstructure:"recordUpload" validate:"required"`
+	// Pin is the admin path to pin recordings, used by `brec-pp pin`; it is disabled if not configured.
+	Pin string `mapstructure:"pin"`
+	// CleanPlan is the admin path to simulate cleaning, used by `brec-pp clean-plan`; it is disabled if not configured.
+	CleanPlan string `mapstructure:"cleanPlan"`
 }
 
 type ServiceRegistry struct {
 	Default   ServiceEntry           `mapstructure:"default" validate:"required"`
-	Streamers []StreamerServiceEntry `mapstructure:"streamers"`
+	Streamers []StreamerServiceEntry `mapstructure:"streamers" validate:"dive"`
 }
 
 type ServiceEntry struct {
@@ -36,14 +57,246 @@ type Discord struct {
 	WebhookURL string `mapstructure:"webhookUrl" validate:"required,url"`
 }
 
+// Storage is the local storage and upload destinations of recordings.
+// Either a single upload destination is configured inline, or a list of them is configured by Destinations.
 type Storage struct {
-	RootPath    string      `mapstructure:"rootPath" validate:"required,dir"`
-	GoogleDrive GoogleDrive `mapstructure:"googleDrive" validate:"required"`
+	RootPath string `mapstructure:"rootPath" validate:"required,dir"`
+	Backend  `mapstructure:",squash"`
+	// Destinations are the upload destinations which every recording is uploaded to.
+	Destinations []Destination `mapstructure:"destinations" validate:"dive"`
+	Retry        Retry         `mapstructure:"retry"`
+
+	// AfterUpload is the action on local recordings once uploaded and verified to all required destinations,
+	// either "keep", "delete", or "move" to UploadedPath keeping relative path. Recordings are kept by default.
+	AfterUpload  string `mapstructure:"afterUpload" validate:"omitempty,oneof=keep delete move"`
+	UploadedPath string `mapstructure:"uploadedPath" validate:"required_if=AfterUpload move"`
+	// Retention is the retention policy of local recordings; recordings are removed from the oldest if not configured.
+	Retention *Retention `mapstructure:"retention"`
+	// DryRun only logs local recordings which would be removed, instead of removing them.
+	DryRun bool `mapstructure:"dryRun"`
+	// Local is the capacity reserved on local drive before recording, and how recordings are removed to reserve it.
+	Local LocalStorage `mapstructure:"local"`
+
+	// Workers overrides the global workers configuration for uploads of each destination of this entry.
+	Workers Workers `mapstructure:"workers"`
+}
+
+// LocalStorage is the capacity reserved on local drive whenever a recording file is opened,
+// which is the largest of the reserves configured; 5 GB is reserved if none is configured.
+// Recordings are removed by grouping to reserve the capacity.
+type LocalStorage struct {
+	// ReservedCapacity is the absolute capacity reserved in bytes.
+	ReservedCapacity uint64 `mapstructure:"reservedCapacity"`
+	// ReservedPercentage is the percentage of disk size reserved.
+	ReservedPercentage float64 `mapstructure:"reservedPercentage" validate:"gte=0,lte=100"`
+	// Adaptive reserves the expected size of the next recording file, estimated from bitrate of recent recordings of the room.
+	Adaptive bool `mapstructure:"adaptive"`
+	// SegmentDuration is the expected duration of a recording file, e.g. the split interval of the recorder,
+	// used by adaptive estimate; the longest duration among recent recordings is used if not configured.
+	SegmentDuration time.Duration `mapstructure:"segmentDuration" validate:"gte=0"`
+	// ReservedFiles is the count of free inodes reserved, for small file systems could run out of inodes first.
+	ReservedFiles uint64 `mapstructure:"reservedFiles"`
+	// Quota is the total size of files allowed under root path, e.g. the user quota of the mount;
+	// capacity is only limited by the file system if not configured.
+	Quota uint64 `mapstructure:"quota"`
+	// Grouping is the unit of recordings removed together to reserve capacity, either "file", "session",
+	// or "directory" for layouts keeping every session in its own directory; files are removed one by one by default.
+	Grouping string `mapstructure:"grouping" validate:"omitempty,oneof=file session directory"`
+}
+
+// Retention is the retention policy of recordings, enforced whenever capacity of the storage is ensured.
+// Recordings are grouped by streamer, which is the first folder of their paths if unknown otherwise.
+type Retention struct {
+	// MaxAge is the age after which recordings are removed; recordings are kept regardless of age if zero.
+	MaxAge time.Duration `mapstructure:"maxAge" validate:"gte=0"`
+	// MinAge is the age before which recordings are never removed.
+	MinAge time.Duration `mapstructure:"minAge" validate:"gte=0"`
+	// KeepSessions is the count of latest recording sessions of each streamer never removed.
+	KeepSessions uint `mapstructure:"keepSessions"`
+	// StreamerQuota is the total size of recordings of each streamer, above which recordings are removed from the oldest.
+	StreamerQuota uint64 `mapstructure:"streamerQuota"`
+	// Protected are glob patterns of recording paths never removed, e.g. "22637261-*/*.flv";
+	// a pattern matching a folder protects all recordings under it.
+	Protected []string `mapstructure:"protected"`
+}
+
+// Destination is a named upload destination.
+type Destination struct {
+	// Name identifies the destination in the entry; it must be unique and stable across restarts.
+	Name string `mapstructure:"name" validate:"required"`
+	// Optional destinations are not required to succeed for a recording to be reported as archived.
+	Optional bool `mapstructure:"optional"`
+	Backend  `mapstructure:",squash"`
+}
+
+// Backend is the storage service to upload to; exactly one of them should be configured.
+type Backend struct {
+	GoogleDrive *GoogleDrive `mapstructure:"googleDrive"`
+	S3          *S3          `mapstructure:"s3"`
+	WebDAV      *WebDAV      `mapstructure:"webdav"`
+	SFTP        *SFTP        `mapstructure:"sftp"`
+	LocalMirror *LocalMirror `mapstructure:"localMirror"`
+}
+
+// Configured returns the count of storage services configured.
+func (b *Backend) Configured() int {
+	count := 0
+	for _, configured := range []bool{
+		b.GoogleDrive != nil, b.S3 != nil, b.WebDAV != nil, b.SFTP != nil, b.LocalMirror != nil,
+	} {
+		if configured {
+			count++
+		}
+	}
+	return count
+}
+
+// movesSource reports if the backend removes the source file once uploaded, i.e. local mirror in "move" mode.
+func (b *Backend) movesSource() bool {
+	return b.LocalMirror != nil && b.LocalMirror.Mode == "move"
+}
+
+// verifiesChecksum reports if the backend verifies uploads by checksum, which is required to act after upload.
+// S3 only verifies objects uploaded in a single part, so others are kept locally.
+func (b *Backend) verifiesChecksum() bool {
+	return b.GoogleDrive != nil || b.S3 != nil || (b.LocalMirror != nil && b.LocalMirror.VerifyChecksum)
+}
+
+// EnableDryRun enables dry run of the configured storage services.
+func (b *Backend) EnableDryRun() {
+	if b.GoogleDrive != nil {
+		b.GoogleDrive.DryRun = true
+	}
+	if b.S3 != nil {
+		b.S3.DryRun = true
+	}
+	if b.WebDAV != nil {
+		b.WebDAV.DryRun = true
+	}
+	if b.SFTP != nil {
+		b.SFTP.DryRun = true
+	}
+	if b.LocalMirror != nil {
+		b.LocalMirror.DryRun = true
+	}
+}
+
+// Workers is the configuration of upload worker pool.
+type Workers struct {
+	// Concurrency is the maximum count of concurrent uploads per destination.
+	Concurrency uint `mapstructure:"concurrency"`
+	// Ordering is the order to upload pending files, either "fifo" or "oldestFirst".
+	Ordering string `mapstructure:"ordering" validate:"omitempty,oneof=fifo oldestFirst"`
+}
+
+// Retry is the retry policy of failed uploads.
+// Defaults would be used for fields not configured.
+type Retry struct {
+	MaxAttempts    uint          `mapstructure:"maxAttempts"`
+	InitialBackoff time.Duration `mapstructure:"initialBackoff" validate:"gte=0"`
+	MaxBackoff     time.Duration `mapstructure:"maxBackoff" validate:"gte=0"`
+	Jitter         float64       `mapstructure:"jitter" validate:"gte=0,lte=1"`
 }
 
 type GoogleDrive struct {
-	Timeout          time.Duration `mapstructure:"timeout" validate:"required,gt=0"`
-	CredentialPath   string        `mapstructure:"credentialPath" validate:"required,file"`
-	ReservedCapacity uint64        `mapstructure:"reservedCapacity" validate:"required"`
-	ParentFolderID   string        `mapstructure:"parentFolderId" validate:"required"`
+	Timeout time.Duration `mapstructure:"timeout" validate:"required,gt=0"`
+	// CredentialType is either "serviceAccount" or "oauthUser"; default is "serviceAccount".
+	// CredentialPath is the JSON key of service account, or the OAuth client credential of installed app for "oauthUser".
+	CredentialType string `mapstructure:"credentialType" validate:"omitempty,oneof=serviceAccount oauthUser"`
+	CredentialPath string `mapstructure:"credentialPath" validate:"required,file"`
+	// TokenPath is the token file of personal account authorized by `brec-pp gdrive-auth`; required for "oauthUser".
+	TokenPath string `mapstructure:"tokenPath" validate:"required_if=CredentialType oauthUser"`
+
+	ReservedCapacity uint64 `mapstructure:"reservedCapacity" validate:"required"`
+	ParentFolderID   string `mapstructure:"parentFolderId" validate:"required"`
+	// SharedDriveID is the shared drive containing ParentFolderID, if recordings are uploaded to a shared drive.
+	// As shared drives have no storage quota of their own, capacity is Quota minus size of files under ParentFolderID.
+	SharedDriveID string `mapstructure:"sharedDriveId"`
+	Quota         uint64 `mapstructure:"quota" validate:"required_with=SharedDriveID"`
+	// TrashGracePeriod is the period to keep removed recordings in trash before purged, if configured.
+	// Recordings are deleted permanently on removal by default.
+	TrashGracePeriod time.Duration `mapstructure:"trashGracePeriod" validate:"gte=0"`
+	// PurgeTrashEarly allows purging recordings in trash within grace period if the capacity is needed for upload;
+	// the upload fails instead by default.
+	PurgeTrashEarly bool `mapstructure:"purgeTrashEarly"`
+	// PathTemplate is the folder path under ParentFolderID to upload recordings to, e.g. "{roomId}-{streamerName}/{yyyy-MM}".
+	// Missing folders are created on upload; recordings are uploaded to ParentFolderID directly if empty.
+	PathTemplate string `mapstructure:"pathTemplate"`
+	// Retention is the retention policy of recordings on google drive.
+	Retention *Retention `mapstructure:"retention"`
+	// DryRun only logs recordings which would be removed to ensure capacity, instead of removing them.
+	DryRun bool `mapstructure:"dryRun"`
+
+	// ChunkSize is the size of each chunk in resumable upload, rounded down to multiple of 256 KB.
+	// Default chunk size of 16 MB is used if not configured.
+	ChunkSize uint64 `mapstructure:"chunkSize"`
+}
+
+// S3 is the configuration of S3 compatible object storage.
+type S3 struct {
+	Timeout time.Duration `mapstructure:"timeout" validate:"required,gt=0"`
+	// Endpoint is the host, with optional port, of the S3 service, e.g. "s3.amazonaws.com" or "localhost:9000".
+	Endpoint        string `mapstructure:"endpoint" validate:"required"`
+	Region          string `mapstructure:"region"`
+	Insecure        bool   `mapstructure:"insecure"`
+	AccessKeyID     string `mapstructure:"accessKeyId" validate:"required"`
+	SecretAccessKey string `mapstructure:"secretAccessKey" validate:"required"`
+	Bucket          string `mapstructure:"bucket" validate:"required"`
+	// Prefix is the key prefix for uploaded recordings; recordings are uploaded to bucket root if empty.
+	Prefix string `mapstructure:"prefix"`
+
+	// Quota is the capacity of bucket allowed to be used by recordings under Prefix.
+	Quota            uint64 `mapstructure:"quota" validate:"required"`
+	ReservedCapacity uint64 `mapstructure:"reservedCapacity"`
+	DryRun           bool   `mapstructure:"dryRun"`
+
+	// PartSize is the size of each part in multipart upload, at least 5 MB; it is decided by file size if not configured.
+	PartSize uint64 `mapstructure:"partSize" validate:"omitempty,gte=5242880"`
+}
+
+// WebDAV is the configuration of WebDAV server, e.g. Nextcloud or ownCloud.
+type WebDAV struct {
+	Timeout time.Duration `mapstructure:"timeout" validate:"required,gt=0"`
+	// URL is the collection to upload recordings to, which should exist on the server.
+	// e.g. "https://cloud.example.com/remote.php/dav/files/user/recordings"
+	URL      string `mapstructure:"url" validate:"required,url"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+
+	ReservedCapacity uint64 `mapstructure:"reservedCapacity"`
+	DryRun           bool   `mapstructure:"dryRun"`
+}
+
+// SFTP is the configuration of SSH server with SFTP subsystem, e.g. a NAS.
+type SFTP struct {
+	Timeout time.Duration `mapstructure:"timeout" validate:"required,gt=0"`
+	// Address is the host and port of the server, e.g. "nas.local:22".
+	Address  string `mapstructure:"address" validate:"required,hostname_port"`
+	Username string `mapstructure:"username" validate:"required"`
+	// Password is used for authentication if PrivateKeyPath is not configured.
+	Password             string `mapstructure:"password" validate:"required_without=PrivateKeyPath"`
+	PrivateKeyPath       string `mapstructure:"privateKeyPath" validate:"omitempty,file"`
+	PrivateKeyPassphrase string `mapstructure:"privateKeyPassphrase"`
+	// KnownHostsPath is the known_hosts file to verify the host key of the server.
+	KnownHostsPath string `mapstructure:"knownHostsPath" validate:"required,file"`
+	// RemotePath is the directory to upload recordings to, which should exist on the server.
+	RemotePath string `mapstructure:"remotePath" validate:"required"`
+
+	ReservedCapacity uint64 `mapstructure:"reservedCapacity"`
+	DryRun           bool   `mapstructure:"dryRun"`
+}
+
+// LocalMirror is the configuration of mirroring recordings to another local path, e.g. a mounted disk.
+type LocalMirror struct {
+	Timeout time.Duration `mapstructure:"timeout" validate:"required,gt=0"`
+	// Path is the directory to mirror recordings to, which should exist.
+	Path string `mapstructure:"path" validate:"required,dir"`
+	// Mode is either "copy" or "move"; recordings are removed from RootPath once mirrored in "move" mode,
+	// which is only allowed for the only destination without AfterUpload. Default mode is "copy".
+	Mode string `mapstructure:"mode" validate:"omitempty,oneof=copy move"`
+	// VerifyChecksum enables comparing SHA-256 checksum of mirrored file, in addition to its size.
+	VerifyChecksum bool `mapstructure:"verifyChecksum"`
+
+	ReservedCapacity uint64 `mapstructure:"reservedCapacity"`
+	DryRun           bool   `mapstructure:"dryRun"`
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -30,14 +31,16 @@ func NewNotifier(
 		client:      NewClient(logger, webhookURL),
 		updateQueue: updateQueue,
 		biliClient:  biliClient,
+		sessions:    notification.NewSessions(),
 	}
 
 	go func() {
 		for updateMsg := range updateQueue {
-			ctx, _ := context.WithTimeout(context.Background(), 45*time.Second)
+			ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
 			if err := n.updateImages(ctx, updateMsg); err != nil {
 				n.logger.Error("error updating image async", zap.Error(err))
 			}
+			cancel()
 		}
 	}()
 
@@ -50,6 +53,8 @@ type notifier struct {
 	client      Client
 	updateQueue chan *updateMessage
 	biliClient  bilibili.Client
+	// sessions summarize files closed of recording sessions, reported when sessions end.
+	sessions *notification.Sessions
 }
 
 func (n *notifier) OnRecordStart(
@@ -108,6 +113,7 @@ func (n *notifier) OnRecordReady(
 	eventTime time.Time,
 	eventData *brec.EventDataFileClose,
 ) error {
+	n.sessions.Observe(eventData)
 	message := &WebhookMessage{
 		Embeds: []*MessageEmbed{{
 			Author: &MessageEmbedAuthor{
@@ -160,6 +166,66 @@ func (n *notifier) OnRecordReady(
 	return nil
 }
 
+func (n *notifier) OnRecordEnd(
+	ctx context.Context,
+	eventTime time.Time,
+	eventData *brec.EventDataSession,
+) error {
+	summary := n.sessions.End(eventData.SessionID)
+	message := &WebhookMessage{
+		Embeds: []*MessageEmbed{{
+			Author: &MessageEmbedAuthor{
+				Name: eventData.StreamerName,
+				URL:  fmt.Sprintf("https://live.bilibili.com/%d", eventData.RoomID),
+			},
+			Title:       "Recording ended",
+			Type:        WebhookEmbedType,
+			Description: eventData.Title,
+			Timestamp:   eventTime.Format(time.RFC3339),
+			Color:       0x6633FF,
+			Fields: []*MessageEmbedField{
+				{
+					Name:   "Segments",
+					Value:  strconv.Itoa(summary.Segments),
+					Inline: true,
+				},
+				{
+					Name:   "Total Size",
+					Value:  fmt.Sprintf("%.3f GB", float64(summary.TotalSize)/storage.GigaBytes),
+					Inline: true,
+				},
+				{
+					Name:   "Total Duration",
+					Value:  summary.TotalDuration.String(),
+					Inline: true,
+				},
+				{
+					Name:  "Available Space on Recoder",
+					Value: n.safeGetAvailableCapacity(),
+				},
+			},
+		}},
+	}
+
+	response, err := n.client.Send(ctx, message)
+	if err != nil {
+		return errors.Wrap(err, "error sending OnRecordEnd notification to discord")
+	}
+
+	select {
+	case n.updateQueue <- &updateMessage{
+		roomID:          eventData.RoomID,
+		messageID:       response.ID,
+		message:         message,
+		usingEmbedImage: nil, // not using embed image
+	}:
+	case <-ctx.Done():
+		n.logger.Error("unable to send message for update", zap.Error(ctx.Err()))
+	}
+
+	return nil
+}
+
 func (n *notifier) OnUploadComplete(
 	ctx context.Context,
 	timestamp time.Time,
@@ -208,6 +274,86 @@ func (n *notifier) OnUploadComplete(
 	return nil
 }
 
+func (n *notifier) OnStreamStart(
+	ctx context.Context,
+	eventTime time.Time,
+	eventData *brec.EventDataBase,
+) error {
+	message := &WebhookMessage{
+		Embeds: []*MessageEmbed{{
+			Author: &MessageEmbedAuthor{
+				Name: eventData.StreamerName,
+				URL:  fmt.Sprintf("https://live.bilibili.com/%d", eventData.RoomID),
+			},
+			Title:       "Livestream started",
+			Type:        WebhookEmbedType,
+			Description: eventData.Title,
+			Timestamp:   eventTime.Format(time.RFC3339),
+			Color:       0xFF9900,
+			Fields: []*MessageEmbedField{{
+				Name:  "Area",
+				Value: eventData.AreaNameParent + " / " + eventData.AreaNameChild,
+			}},
+		}},
+	}
+
+	response, err := n.client.Send(ctx, message)
+	if err != nil {
+		return errors.Wrap(err, "error sending OnStreamStart notification to discord")
+	}
+
+	select {
+	case n.updateQueue <- &updateMessage{
+		roomID:          eventData.RoomID,
+		messageID:       response.ID,
+		message:         message,
+		usingEmbedImage: usingCover,
+	}:
+	case <-ctx.Done():
+		n.logger.Error("unable to send message for update", zap.Error(ctx.Err()))
+	}
+
+	return nil
+}
+
+func (n *notifier) OnStreamEnd(
+	ctx context.Context,
+	eventTime time.Time,
+	eventData *brec.EventDataBase,
+) error {
+	message := &WebhookMessage{
+		Embeds: []*MessageEmbed{{
+			Author: &MessageEmbedAuthor{
+				Name: eventData.StreamerName,
+				URL:  fmt.Sprintf("https://live.bilibili.com/%d", eventData.RoomID),
+			},
+			Title:       "Livestream ended",
+			Type:        WebhookEmbedType,
+			Description: eventData.Title,
+			Timestamp:   eventTime.Format(time.RFC3339),
+			Color:       0x996633,
+		}},
+	}
+
+	response, err := n.client.Send(ctx, message)
+	if err != nil {
+		return errors.Wrap(err, "error sending OnStreamEnd notification to discord")
+	}
+
+	select {
+	case n.updateQueue <- &updateMessage{
+		roomID:          eventData.RoomID,
+		messageID:       response.ID,
+		message:         message,
+		usingEmbedImage: nil, // not using embed image
+	}:
+	case <-ctx.Done():
+		n.logger.Error("unable to send message for update", zap.Error(ctx.Err()))
+	}
+
+	return nil
+}
+
 func (n *notifier) Alert(ctx context.Context, msg string, err error) {
 	if _, sendErr := n.client.Send(
 		ctx,
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+	"github.com/ayumi-otosaka-314/brec-pp/streamer"
+)
+
+// CleanPlanRequest simulates cleaning of the local drive or an upload destination of the streamer in room,
+// to ensure target capacity. Services of default entry are used if the room is not configured.
+type CleanPlanRequest struct {
+	RoomID uint64 `json:"roomId"`
+	// Destination is the name of upload destination, "upload" for the destination configured inline,
+	// or "local" for the local drive.
+	Destination    string `json:"destination"`
+	TargetCapacity uint64 `json:"targetCapacity"`
+}
+
+// CleanPlan is the result of simulated cleaning.
+type CleanPlan struct {
+	Removals  []storage.PlannedRemoval `json:"removals"`
+	Reclaimed uint64                   `json:"reclaimed"`
+	// Error is the error of simulated cleaning, e.g. the target capacity could not be ensured.
+	Error string `json:"error,omitempty"`
+}
+
+// NewCleanPlanHandler creates the admin handler simulating cleaning by POST of CleanPlanRequest,
+// responding CleanPlan of recordings which would be removed. Nothing is actually removed.
+func NewCleanPlanHandler(logger *zap.Logger, streamerServiceRegistry streamer.ServiceRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		request := &CleanPlanRequest{}
+		if err := jsoniter.NewDecoder(r.Body).Decode(request); err != nil {
+			logger.Error("error decoding request body", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ensurer, ok := streamerServiceRegistry.GetCapacityEnsurers(request.RoomID)[request.Destination]
+		if !ok {
+			http.Error(w, "no storage ensuring capacity named "+request.Destination, http.StatusNotFound)
+			return
+		}
+
+		logger.Info("simulating cleaning", zap.Uint64("roomID", request.RoomID),
+			zap.String("destination", request.Destination), zap.Uint64("targetCapacity", request.TargetCapacity))
+		plan := storage.NewPlan(logger)
+		result := &CleanPlan{}
+		if err := ensurer.EnsureCapacity(storage.WithDryRun(r.Context(), plan), request.TargetCapacity); err != nil {
+			result.Error = err.Error()
+		}
+		result.Removals = plan.Removals()
+		result.Reclaimed = plan.Reclaimed()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := jsoniter.NewEncoder(w).Encode(result); err != nil {
+			logger.Warn("error encoding clean plan", zap.Error(err))
+		}
+	}
+}
+
+// RequestCleanPlan requests the clean plan handler at url, authorized by token, to simulate cleaning.
+func RequestCleanPlan(ctx context.Context, url, token string, request *CleanPlanRequest) (*CleanPlan, error) {
+	raw, err := jsoniter.Marshal(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshalling clean plan request")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(raw))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating clean plan request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setToken(req, token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error requesting clean plan")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, errors.Errorf("unexpected response [%s] to clean plan request: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	plan := &CleanPlan{}
+	return plan, errors.Wrap(jsoniter.NewDecoder(resp.Body).Decode(plan), "error decoding clean plan")
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"sync"
+
+	"github.com/ayumi-otosaka-314/brec-pp/state"
+)
+
+type claimResult uint8
+
+const (
+	// claimed events should be handled, and released afterward.
+	claimed claimResult = iota
+	// claimHandled events have been handled successfully already.
+	claimHandled
+	// claimInFlight events are being handled by another delivery, whose result is unknown yet.
+	claimInFlight
+)
+
+// eventClaims deduplicates webhook events by their IDs, as the recorder redelivers events on failure.
+// Events being handled are claimed in memory, and IDs of events handled successfully are remembered in handled.
+// Events without ID are always handled.
+type eventClaims struct {
+	handled *state.TTLSet
+
+	mu       sync.Mutex
+	inFlight map[string]struct{}
+}
+
+func newEventClaims(handled *state.TTLSet) *eventClaims {
+	return &eventClaims{handled: handled, inFlight: make(map[string]struct{})}
+}
+
+func (c *eventClaims) claim(id string) (claimResult, error) {
+	if id == "" {
+		return claimed, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.inFlight[id]; ok {
+		return claimInFlight, nil
+	}
+	handled, err := c.handled.Contains(id)
+	if err != nil {
+		return claimed, err
+	}
+	if handled {
+		return claimHandled, nil
+	}
+	c.inFlight[id] = struct{}{}
+	return claimed, nil
+}
+
+// release releases the event claimed, and remembers it if handled successfully.
+func (c *eventClaims) release(id string, handled bool) error {
+	if id == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.inFlight, id)
+	if !handled {
+		return nil
+	}
+	return c.handled.Add(id)
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+	"github.com/ayumi-otosaka-314/brec-pp/streamer"
+)
+
+// PinRequest pins recordings of the streamer in room, on local drive and all upload destinations supporting pins.
+// Services of default entry are used if the room is not configured.
+type PinRequest struct {
+	RoomID uint64 `json:"roomId"`
+	storage.Pin
+}
+
+// NewPinHandler creates the admin handler pinning recordings by POST, and unpinning them by DELETE, of PinRequest.
+// Pins are updated within the request, which might take a while to find recordings on upload destinations.
+func NewPinHandler(logger *zap.Logger, streamerServiceRegistry streamer.ServiceRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		pinned := r.Method == http.MethodPost
+
+		request := &PinRequest{}
+		if err := jsoniter.NewDecoder(r.Body).Decode(request); err != nil {
+			logger.Error("error decoding request body", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := request.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		logger.Info("updating pinned recordings", zap.Uint64("roomID", request.RoomID),
+			zap.String("relativePath", request.RelativePath), zap.String("sessionID", request.SessionID),
+			zap.Bool("pinned", pinned))
+		for _, pinner := range streamerServiceRegistry.GetPinners(request.RoomID) {
+			if err := pinner.SetPinned(r.Context(), request.Pin, pinned); err != nil {
+				logger.Error("error updating pinned recordings", zap.Error(err))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RequestPin requests the pin handler at url, authorized by token, to pin recordings, or to unpin them if pinned is false.
+func RequestPin(ctx context.Context, url, token string, request *PinRequest, pinned bool) error {
+	raw, err := jsoniter.Marshal(request)
+	if err != nil {
+		return errors.Wrap(err, "error marshalling pin request")
+	}
+	method := http.MethodPost
+	if !pinned {
+		method = http.MethodDelete
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(raw))
+	if err != nil {
+		return errors.Wrap(err, "error creating pin request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setToken(req, token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error requesting pin")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return errors.Errorf("unexpected response [%s] to pin request: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
@@ -8,21 +8,30 @@ import (
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
 	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/state"
 	"github.com/ayumi-otosaka-314/brec-pp/storage"
 	"github.com/ayumi-otosaka-314/brec-pp/storage/localdrive"
 	"github.com/ayumi-otosaka-314/brec-pp/streamer"
 )
 
+// NewNotifyRecordUploadHandler creates the handler of webhook events of recorder.
+// Events are handled once by their IDs, as the recorder retries webhooks on failure:
+// IDs of events handled successfully are remembered in handledEvents and acknowledged if redelivered,
+// while redelivery of an event being handled is rejected with a retryable status.
 func NewNotifyRecordUploadHandler(
 	logger *zap.Logger,
 	timeout time.Duration,
 	streamerServiceRegistry streamer.ServiceRegistry,
+	handledEvents *state.TTLSet,
 ) http.HandlerFunc {
+	claims := newEventClaims(handledEvents)
 	return func(w http.ResponseWriter, r *http.Request) {
-		rootCtx, _ := context.WithTimeout(context.Background(), timeout)
+		rootCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
 
 		if r.Method != http.MethodPost {
 			logger.Warn("unexpected HTTP method", zap.String("method", r.Method))
@@ -45,6 +54,28 @@ func NewNotifyRecordUploadHandler(
 			return
 		}
 
+		claim, err := claims.claim(event.ID)
+		switch {
+		case err != nil:
+			logger.Error("error checking event handled", zap.Object("Event", event), zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		case claim == claimHandled:
+			logger.Info("ignoring event handled already", zap.Object("Event", event))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case claim == claimInFlight:
+			logger.Info("rejecting event being handled", zap.Object("Event", event))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var handled bool
+		defer func() {
+			if err := claims.release(event.ID, handled); err != nil {
+				logger.Error("error remembering event handled", zap.Object("Event", event), zap.Error(err))
+			}
+		}()
+
 		switch event.Type {
 		case brec.EventTypeSessionStarted:
 			eventData := &brec.EventDataSession{}
@@ -57,6 +88,31 @@ func NewNotifyRecordUploadHandler(
 			err = streamerServiceRegistry.
 				GetNotifier(eventData.RoomID).
 				OnRecordStart(rootCtx, eventTime, eventData)
+		case brec.EventTypeSessionEnded:
+			eventData := &brec.EventDataSession{}
+			if err = jsoniter.Unmarshal(event.Data, eventData); err != nil {
+				logger.Warn("error unmarshalling event data", zap.Error(err))
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			err = streamerServiceRegistry.
+				GetNotifier(eventData.RoomID).
+				OnRecordEnd(rootCtx, eventTime, eventData)
+		case brec.EventTypeStreamStarted, brec.EventTypeStreamEnded:
+			eventData := &brec.EventDataBase{}
+			if err = jsoniter.Unmarshal(event.Data, eventData); err != nil {
+				logger.Warn("error unmarshalling event data", zap.Error(err))
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			notifier := streamerServiceRegistry.GetNotifier(eventData.RoomID)
+			if event.Type == brec.EventTypeStreamStarted {
+				err = notifier.OnStreamStart(rootCtx, eventTime, eventData)
+			} else {
+				err = notifier.OnStreamEnd(rootCtx, eventTime, eventData)
+			}
 		case brec.EventTypeFileOpening:
 			eventData := &brec.EventDataFileOpen{}
 			if err = jsoniter.Unmarshal(event.Data, eventData); err != nil {
@@ -65,16 +121,24 @@ func NewNotifyRecordUploadHandler(
 				return
 			}
 
+			// the recording is tracked before cleaning, so that it is never removed while being written.
+			if openErr := streamerServiceRegistry.GetOpenFiles(eventData.RoomID).Open(eventData.RelativePath); openErr != nil {
+				logger.Error("error tracking open recording; protected until restart", zap.Error(openErr))
+			}
 			go func(e *brec.EventDataFileOpen) {
-				ctx, _ := context.WithTimeout(context.Background(), 10*time.Minute)
-				if err := storage.EnsureCapacity(
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+				defer cancel()
+				if err := streamerServiceRegistry.GetLocalStorage(eventData.RoomID).EnsureTarget(
 					localdrive.WithTraverseDepth(ctx, strings.Count(e.RelativePath, string(os.PathSeparator))),
-					uint64(5*storage.GigaBytes),
-					streamerServiceRegistry.GetLocalStorage(eventData.RoomID),
+					streamerServiceRegistry.GetLocalReserve(e.RoomID).Target(e.RoomID),
 				); err != nil {
 					logger.Error("error cleaning local storage", zap.Error(err))
-					streamerServiceRegistry.GetNotifier(eventData.RoomID).
-						Alert(ctx, "error cleaning local storage", err)
+					message := "error cleaning local storage"
+					if errors.Is(err, storage.ErrCapacityNotEnsured) {
+						message = "unable to ensure local storage capacity without removing protected recordings " +
+							"(being recorded, pending upload, pinned or retained)"
+					}
+					streamerServiceRegistry.GetNotifier(eventData.RoomID).Alert(ctx, message, err)
 				}
 			}(eventData)
 		case brec.EventTypeFileClosed:
@@ -85,17 +149,23 @@ func NewNotifyRecordUploadHandler(
 				return
 			}
 
-			if err = streamerServiceRegistry.
-				GetNotifier(eventData.RoomID).
-				OnRecordReady(rootCtx, eventTime, eventData); err != nil {
-				logger.Warn("error notifying on record finish; continue to upload", zap.Error(err))
+			// the upload is persisted before anything else, so that the recording is never lost once acknowledged,
+			// and the recorder retries the event if it could not be persisted.
+			if err = streamerServiceRegistry.GetUploader(eventData.RoomID).Submit(eventData); err != nil {
+				err = errors.Wrap(err, "error submitting upload")
+				break
+			}
+			// the recording is protected as pending upload once its archive is persisted,
+			// and it is kept open, i.e. protected, if the upload could not be persisted.
+			if closeErr := streamerServiceRegistry.GetOpenFiles(eventData.RoomID).Close(eventData.RelativePath); closeErr != nil {
+				logger.Error("error tracking closed recording; protected after restart", zap.Error(closeErr))
 			}
 
-			select {
-			case streamerServiceRegistry.GetUploader(eventData.RoomID).Receive() <- eventData:
-				err = nil
-			case <-rootCtx.Done():
-				err = rootCtx.Err()
+			streamerServiceRegistry.GetLocalReserve(eventData.RoomID).Observe(eventData)
+			if notifyErr := streamerServiceRegistry.
+				GetNotifier(eventData.RoomID).
+				OnRecordReady(rootCtx, eventTime, eventData); notifyErr != nil {
+				logger.Warn("error notifying on record finish; uploading anyway", zap.Error(notifyErr))
 			}
 		default:
 			logger.Debug("received unqualified event", zap.Object("Event", event))
@@ -105,6 +175,7 @@ func NewNotifyRecordUploadHandler(
 			logger.Warn("error processing event", zap.Object("Event", event), zap.Error(err))
 			w.WriteHeader(http.StatusInternalServerError)
 		} else {
+			handled = true
 			w.WriteHeader(http.StatusNoContent)
 		}
 		return
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/config"
+	"github.com/ayumi-otosaka-314/brec-pp/notification"
+	"github.com/ayumi-otosaka-314/brec-pp/state"
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+	"github.com/ayumi-otosaka-314/brec-pp/storage/localdrive"
+	"github.com/ayumi-otosaka-314/brec-pp/upload"
+)
+
+func TestNotifyRecordUploadHandler_redelivery(t *testing.T) {
+	store, err := state.Open(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	registry := newFakeRegistry(t)
+	handler := NewNotifyRecordUploadHandler(zaptest.NewLogger(t), time.Second, registry,
+		state.NewTTLSet(store, "handledEvents", time.Hour))
+	post := func(eventType brec.EventType, id, data string) int {
+		body := fmt.Sprintf(`{"EventType":%q,"EventTimestamp":"2024-01-01T00:00:00.0000000+08:00","EventId":%q,"EventData":%s}`,
+			eventType, id, data)
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(body)))
+		return w.Code
+	}
+	const fileData = `{"RoomId":1,"RelativePath":"room/1.flv","FileSize":1000,"Duration":10,"SessionId":"s"}`
+
+	assert.Equal(t, http.StatusNoContent, post(brec.EventTypeFileOpening, "open", fileData))
+	assert.True(t, registry.openFiles.IsOpen("room/1.flv"))
+
+	registry.uploader.errs = []error{errors.New("persistence failed")}
+	assert.Equal(t, http.StatusInternalServerError, post(brec.EventTypeFileClosed, "close", fileData))
+	assert.Zero(t, registry.notifier.ready, "nothing should be done if upload is not persisted")
+	assert.True(t, registry.openFiles.IsOpen("room/1.flv"), "recording should be protected if upload is not persisted")
+
+	assert.Equal(t, http.StatusNoContent, post(brec.EventTypeFileClosed, "close", fileData))
+	assert.Equal(t, 1, registry.notifier.ready)
+	assert.False(t, registry.openFiles.IsOpen("room/1.flv"))
+
+	assert.Equal(t, http.StatusNoContent, post(brec.EventTypeFileClosed, "close", fileData))
+	assert.Equal(t, 1, registry.notifier.ready, "event handled already should not be handled again")
+	assert.Equal(t, 2, registry.uploader.submitted)
+
+	// redelivery of an event being handled should be retried later.
+	registry.uploader.entered = make(chan struct{})
+	registry.uploader.release = make(chan struct{})
+	first := make(chan int)
+	go func() { first <- post(brec.EventTypeFileClosed, "close2", fileData) }()
+	<-registry.uploader.entered
+	assert.Equal(t, http.StatusServiceUnavailable, post(brec.EventTypeFileClosed, "close2", fileData))
+	close(registry.uploader.release)
+	assert.Equal(t, http.StatusNoContent, <-first)
+	assert.Equal(t, 2, registry.notifier.ready)
+}
+
+type fakeRegistry struct {
+	notifier  *fakeNotifier
+	uploader  *fakeUploader
+	reserve   *localdrive.Reserve
+	openFiles *localdrive.OpenFiles
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	return &fakeRegistry{
+		notifier:  &fakeNotifier{},
+		uploader:  &fakeUploader{},
+		reserve:   localdrive.NewReserve(config.LocalStorage{}, t.TempDir()),
+		openFiles: localdrive.NewOpenFiles(),
+	}
+}
+
+func (r *fakeRegistry) GetNotifier(uint64) notification.Service      { return r.notifier }
+func (r *fakeRegistry) GetLocalStorage(uint64) storage.TargetEnsurer { return noopEnsurer{} }
+func (r *fakeRegistry) GetLocalReserve(uint64) *localdrive.Reserve   { return r.reserve }
+func (r *fakeRegistry) GetOpenFiles(uint64) *localdrive.OpenFiles    { return r.openFiles }
+func (r *fakeRegistry) GetUploader(uint64) upload.Service            { return r.uploader }
+func (r *fakeRegistry) GetPinners(uint64) []storage.Pinner           { return nil }
+func (r *fakeRegistry) GetCapacityEnsurers(uint64) map[string]storage.CapacityEnsurer {
+	return nil
+}
+
+type noopEnsurer struct{}
+
+func (noopEnsurer) EnsureCapacity(context.Context, uint64) error       { return nil }
+func (noopEnsurer) EnsureTarget(context.Context, storage.Target) error { return nil }
+
+// fakeUploader fails submissions by errs in order, and blocks them until released if entered is set.
+type fakeUploader struct {
+	mu        sync.Mutex
+	errs      []error
+	submitted int
+	entered   chan struct{}
+	release   chan struct{}
+}
+
+func (u *fakeUploader) Close() {}
+
+func (u *fakeUploader) Submit(*brec.EventDataFileClose) error {
+	if u.entered != nil {
+		close(u.entered)
+		<-u.release
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.submitted++
+	if len(u.errs) > 0 {
+		err := u.errs[0]
+		u.errs = u.errs[1:]
+		return err
+	}
+	return nil
+}
+
+type fakeNotifier struct {
+	notification.Service
+	mu    sync.Mutex
+	ready int
+}
+
+func (n *fakeNotifier) OnRecordReady(context.Context, time.Time, *brec.EventDataFileClose) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.ready++
+	return nil
+}
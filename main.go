@@ -1,19 +1,133 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
 
 	"github.com/ayumi-otosaka-314/brec-pp/config"
+	"github.com/ayumi-otosaka-314/brec-pp/handler"
 	"github.com/ayumi-otosaka-314/brec-pp/registry"
+	"github.com/ayumi-otosaka-314/brec-pp/storage/gdrive"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "gdrive-auth" {
+		if err := gdriveAuth(os.Args[2:]); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "clean-plan" {
+		if err := cleanPlan(os.Args[2:]); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "pin" {
+		if err := pin(os.Args[2:]); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
 	conf, err := config.New()
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	r := registry.New(conf)
+	r, err := registry.New(conf)
+	if err != nil {
+		log.Fatalln(err)
+	}
 	r.NewServer().Serve()
 	r.CleanUp()
 }
+
+// gdriveAuth authorizes a personal google account to upload to google drive, and writes its token file.
+func gdriveAuth(args []string) error {
+	flags := pflag.NewFlagSet("gdrive-auth", pflag.ExitOnError)
+	credentialPath := flags.String("credential", "", "path to OAuth client credential of installed app")
+	tokenPath := flags.String("token", "", "path to write token file to")
+	_ = flags.Parse(args)
+	if *credentialPath == "" || *tokenPath == "" {
+		return errors.New("both --credential and --token are required")
+	}
+	return gdrive.Authorize(context.Background(), *credentialPath, *tokenPath, os.Stdin, os.Stdout)
+}
+
+// pin requests the running server to pin or unpin recordings, via the admin path configured.
+func pin(args []string) error {
+	flags := pflag.NewFlagSet("pin", pflag.ExitOnError)
+	configPath := flags.String("config", "", "path to config file")
+	request := &handler.PinRequest{}
+	flags.Uint64Var(&request.RoomID, "room", 0, "room ID of the streamer; default entry is used if not configured")
+	flags.StringVar(&request.RelativePath, "path", "", "path of recording or directory relative to root path")
+	flags.StringVar(&request.SessionID, "session", "", "recording session ID")
+	unpin := flags.Bool("unpin", false, "unpin recordings instead")
+	_ = flags.Parse(args)
+
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	if conf.Server.Paths.Pin == "" {
+		return errors.New("admin path to pin is not configured by server.paths.pin")
+	}
+	if err = request.Validate(); err != nil {
+		return err
+	}
+	if err = handler.RequestPin(
+		context.Background(),
+		"http://"+conf.Server.ListenAddress+conf.Server.Paths.Pin,
+		conf.Server.AdminToken,
+		request,
+		!*unpin,
+	); err != nil {
+		return err
+	}
+	log.Println("pins updated")
+	return nil
+}
+
+// cleanPlan requests the running server to simulate cleaning to ensure target capacity, and prints the plan.
+func cleanPlan(args []string) error {
+	flags := pflag.NewFlagSet("clean-plan", pflag.ExitOnError)
+	configPath := flags.String("config", "", "path to config file")
+	request := &handler.CleanPlanRequest{}
+	flags.Uint64Var(&request.RoomID, "room", 0, "room ID of the streamer; default entry is used if not configured")
+	flags.StringVar(&request.Destination, "destination", "local",
+		`name of upload destination, "upload" for the destination configured inline, or "local" for local drive`)
+	flags.Uint64Var(&request.TargetCapacity, "target", 0, "target capacity in bytes")
+	_ = flags.Parse(args)
+
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	if conf.Server.Paths.CleanPlan == "" {
+		return errors.New("admin path to simulate cleaning is not configured by server.paths.cleanPlan")
+	}
+	plan, err := handler.RequestCleanPlan(
+		context.Background(),
+		"http://"+conf.Server.ListenAddress+conf.Server.Paths.CleanPlan,
+		conf.Server.AdminToken,
+		request,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, removal := range plan.Removals {
+		fmt.Printf("%12d  %s\n", removal.Size, removal.Item)
+	}
+	fmt.Printf("%d item(s) would be removed, reclaiming %d bytes\n", len(plan.Removals), plan.Reclaimed)
+	if plan.Error != "" {
+		fmt.Printf("target capacity could not be ensured: %s\n", plan.Error)
+	}
+	return nil
+}
@@ -10,6 +10,10 @@ import (
 type Service interface {
 	OnRecordStart(context.Context, time.Time, *brec.EventDataSession) error
 	OnRecordReady(context.Context, time.Time, *brec.EventDataFileClose) error
+	// OnRecordEnd is called when the recording session ends, after all its files are closed.
+	OnRecordEnd(context.Context, time.Time, *brec.EventDataSession) error
+	OnStreamStart(context.Context, time.Time, *brec.EventDataBase) error
+	OnStreamEnd(context.Context, time.Time, *brec.EventDataBase) error
 	OnUploadComplete(context.Context, time.Time, *brec.EventDataFileClose, time.Duration) error
 	Alert(context.Context, string, error)
 }
@@ -0,0 +1,55 @@
+package notification
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+)
+
+// SessionSummary is the summary of recording files closed during a recording session.
+type SessionSummary struct {
+	Segments      int
+	TotalSize     uint64
+	TotalDuration time.Duration
+}
+
+// Sessions accumulates summaries of recording sessions in memory, from files closed until the session ends.
+// Files closed before restart are not counted.
+type Sessions struct {
+	mu        sync.Mutex
+	summaries map[string]*SessionSummary
+}
+
+func NewSessions() *Sessions {
+	return &Sessions{summaries: make(map[string]*SessionSummary)}
+}
+
+// Observe adds the recording file closed to the summary of its session.
+func (s *Sessions) Observe(eventData *brec.EventDataFileClose) {
+	if eventData.SessionID == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary, ok := s.summaries[eventData.SessionID]
+	if !ok {
+		summary = &SessionSummary{}
+		s.summaries[eventData.SessionID] = summary
+	}
+	summary.Segments++
+	summary.TotalSize += eventData.FileSize
+	summary.TotalDuration += time.Duration(eventData.Duration * float64(time.Second))
+}
+
+// End returns the summary of the session ended, and forgets it.
+func (s *Sessions) End(sessionID string) SessionSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary, ok := s.summaries[sessionID]
+	if !ok {
+		return SessionSummary{}
+	}
+	delete(s.summaries, sessionID)
+	return *summary
+}
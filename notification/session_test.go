@@ -0,0 +1,24 @@
+package notification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+)
+
+func TestSessions(t *testing.T) {
+	t.Parallel()
+
+	s := NewSessions()
+	s.Observe(&brec.EventDataFileClose{SessionID: "s1", FileSize: 100, Duration: 1.5})
+	s.Observe(&brec.EventDataFileClose{SessionID: "s1", FileSize: 200, Duration: 2})
+	s.Observe(&brec.EventDataFileClose{SessionID: "s2", FileSize: 300, Duration: 3})
+	s.Observe(&brec.EventDataFileClose{FileSize: 400, Duration: 4})
+
+	assert.Equal(t, SessionSummary{Segments: 2, TotalSize: 300, TotalDuration: 3500 * time.Millisecond}, s.End("s1"))
+	assert.Equal(t, SessionSummary{}, s.End("s1"), "summary should be forgotten once ended")
+	assert.Equal(t, SessionSummary{Segments: 1, TotalSize: 300, TotalDuration: 3 * time.Second}, s.End("s2"))
+}
@@ -1,7 +1,12 @@
 package registry
 
 import (
+	"context"
 	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -10,9 +15,14 @@ import (
 	"github.com/ayumi-otosaka-314/brec-pp/discord"
 	"github.com/ayumi-otosaka-314/brec-pp/handler"
 	"github.com/ayumi-otosaka-314/brec-pp/notification"
+	"github.com/ayumi-otosaka-314/brec-pp/state"
 	"github.com/ayumi-otosaka-314/brec-pp/storage"
 	"github.com/ayumi-otosaka-314/brec-pp/storage/gdrive"
 	"github.com/ayumi-otosaka-314/brec-pp/storage/localdrive"
+	"github.com/ayumi-otosaka-314/brec-pp/storage/localmirror"
+	"github.com/ayumi-otosaka-314/brec-pp/storage/s3"
+	"github.com/ayumi-otosaka-314/brec-pp/storage/sftp"
+	"github.com/ayumi-otosaka-314/brec-pp/storage/webdav"
 	"github.com/ayumi-otosaka-314/brec-pp/streamer"
 	"github.com/ayumi-otosaka-314/brec-pp/upload"
 )
@@ -20,13 +30,27 @@ import (
 type Registry struct {
 	conf   *config.Root
 	logger *zap.Logger
+	store  *state.Store
+
+	// ctx is cancelled on CleanUp, to stop background tasks of services tracked by background.
+	ctx        context.Context
+	cancel     context.CancelFunc
+	background sync.WaitGroup
 }
 
-func New(conf *config.Root) *Registry {
+func New(conf *config.Root) (*Registry, error) {
+	store, err := state.Open(conf.State.Directory)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Registry{
 		conf:   conf,
 		logger: NewLogger(),
-	}
+		store:  store,
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
 }
 
 func NewLogger() *zap.Logger {
@@ -43,31 +67,112 @@ func NewLogger() *zap.Logger {
 }
 
 func (r *Registry) NewServer() *handler.Server {
+	serviceRegistry := r.NewServiceRegistry()
 	mux := http.NewServeMux()
 	mux.HandleFunc(
 		r.conf.Server.Paths.RecordUpload,
 		handler.NewNotifyRecordUploadHandler(
 			r.logger,
 			r.conf.Server.Timeout,
-			r.NewServiceRegistry(),
+			serviceRegistry,
+			state.NewTTLSet(r.store, "handledEvents", r.eventTTL()),
 		),
 	)
+	if r.conf.Server.Paths.Pin != "" {
+		mux.HandleFunc(r.conf.Server.Paths.Pin, handler.RequireToken(
+			r.conf.Server.AdminToken,
+			handler.NewPinHandler(r.logger, serviceRegistry),
+		))
+	}
+	if r.conf.Server.Paths.CleanPlan != "" {
+		mux.HandleFunc(r.conf.Server.Paths.CleanPlan, handler.RequireToken(
+			r.conf.Server.AdminToken,
+			handler.NewCleanPlanHandler(r.logger, serviceRegistry),
+		))
+	}
 	return handler.NewServer(r.logger, r.conf.Server.ListenAddress, mux)
 }
 
+// eventTTL returns how long IDs of events handled are remembered, falling back to 24 hours.
+func (r *Registry) eventTTL() time.Duration {
+	if r.conf.Server.EventTTL > 0 {
+		return r.conf.Server.EventTTL
+	}
+	return 24 * time.Hour
+}
+
+// finishedTTL returns how long finished upload jobs and archives are kept, falling back to 7 days.
+func (r *Registry) finishedTTL() time.Duration {
+	if r.conf.State.FinishedTTL > 0 {
+		return r.conf.State.FinishedTTL
+	}
+	return 7 * 24 * time.Hour
+}
+
 func (r *Registry) CleanUp() {
+	r.cancel()
+	r.background.Wait()
+	if err := r.store.Close(); err != nil {
+		r.logger.Error("error closing state store", zap.Error(err))
+	}
 	r.logger.Sync()
 }
 
 func (r *Registry) NewServiceRegistry() streamer.ServiceRegistry {
+	entries := map[string]config.ServiceEntry{defaultEntryName: r.conf.Services.Default}
+	for _, entry := range r.conf.Services.Streamers {
+		entries[strconv.FormatUint(entry.RoomID, 10)] = entry.ServiceEntry
+	}
+	archives := make(map[string]*upload.Archives, len(entries))
+	roots := make(map[string]*rootServices)
+	for name, conf := range entries {
+		archives[name] = upload.NewArchives(r.store, name, r.finishedTTL())
+		rootPath := filepath.Clean(conf.Storage.RootPath)
+		root, ok := roots[rootPath]
+		if !ok {
+			root = &rootServices{
+				openFiles: r.loadOpenFiles(rootPath),
+				pins:      localdrive.NewPins(r.store, rootPath),
+			}
+			roots[rootPath] = root
+		}
+		root.archives = append(root.archives, archives[name])
+	}
+
+	newServiceEntry := func(name string) *serviceEntry {
+		conf := entries[name]
+		return r.newServiceEntry(name, conf, archives[name], roots[filepath.Clean(conf.Storage.RootPath)])
+	}
 	mapping := make(map[uint64]*serviceEntry, len(r.conf.Services.Streamers))
 	for _, entry := range r.conf.Services.Streamers {
-		mapping[entry.RoomID] = r.newServiceEntry(entry.ServiceEntry)
+		mapping[entry.RoomID] = newServiceEntry(strconv.FormatUint(entry.RoomID, 10))
 	}
 	return &serviceRegistry{
 		mapping:      mapping,
-		defaultEntry: r.newServiceEntry(r.conf.Services.Default),
+		defaultEntry: newServiceEntry(defaultEntryName),
+	}
+}
+
+// loadOpenFiles loads recordings being written under rootPath before restart, which are still protected
+// until FileClosed of them.
+func (r *Registry) loadOpenFiles(rootPath string) *localdrive.OpenFiles {
+	openFiles, err := localdrive.LoadOpenFiles(r.store, rootPath)
+	if err != nil {
+		panic(err)
 	}
+	if paths := openFiles.Paths(); len(paths) > 0 {
+		r.logger.Info("protecting recordings opened before restart",
+			zap.String("rootPath", rootPath), zap.Strings("paths", paths))
+	}
+	return openFiles
+}
+
+// rootServices are shared by entries of the same rootPath, as their local drives clean the same recordings.
+type rootServices struct {
+	// archives are the upload archives of all entries of rootPath.
+	archives  []localdrive.Archives
+	openFiles *localdrive.OpenFiles
+	pins      *localdrive.Pins
 }
 
 func (r *Registry) newBiliClient() bilibili.Client {
@@ -81,42 +186,205 @@ type serviceRegistry struct {
 
 type serviceEntry struct {
 	notifier     notification.Service
-	localStorage storage.Cleaner
+	localStorage storage.TargetEnsurer
+	localReserve *localdrive.Reserve
+	openFiles    *localdrive.OpenFiles
 	uploader     upload.Service
+	// pinners are the local drive and upload destinations supporting pins.
+	pinners []storage.Pinner
+	// capacityEnsurers are the local drive and upload destinations ensuring capacity, by name.
+	capacityEnsurers map[string]storage.CapacityEnsurer
 }
 
-func (r *Registry) newServiceEntry(conf config.ServiceEntry) *serviceEntry {
-	localStorage := localdrive.New(r.logger, conf.Storage.RootPath)
+const (
+	// defaultEntryName names the default entry, while entries of streamers are named by room ID.
+	defaultEntryName = "default"
+	// localStorageName names the local drive among capacity ensurers of an entry.
+	localStorageName = "local"
+	// inlineDestinationName names the destination configured inline among capacity ensurers of an entry.
+	inlineDestinationName = "upload"
+)
+
+// newServiceEntry creates services for the entry, protecting recordings of all entries sharing its root on local drive.
+// The name identifies the entry's persistent upload queue, so it must be stable across restarts.
+func (r *Registry) newServiceEntry(
+	name string,
+	conf config.ServiceEntry,
+	archives *upload.Archives,
+	root *rootServices,
+) *serviceEntry {
+	localStorage := storage.NewRetention(
+		r.logger,
+		localdrive.New(
+			r.logger,
+			conf.Storage.RootPath,
+			conf.Storage.Local,
+			localdrive.JoinArchives(root.archives...),
+			root.pins,
+			root.openFiles,
+		),
+		conf.Storage.Retention,
+	)
 	notifier := discord.NewNotifier(
 		r.logger,
 		conf.Discord.WebhookURL,
 		localStorage,
 		r.newBiliClient(),
 	)
-	return &serviceEntry{
-		notifier:     notifier,
-		localStorage: localStorage,
-		uploader: gdrive.NewUploadService(
-			r.logger,
-			&conf.Storage.GoogleDrive,
-			conf.Storage.RootPath,
-			notifier,
-		),
+
+	entry := &serviceEntry{
+		notifier:         notifier,
+		localStorage:     storage.NewCapacityEnsurer(r.logger, localStorage, conf.Storage.DryRun || r.conf.DryRun),
+		localReserve:     localdrive.NewReserve(conf.Storage.Local, conf.Storage.RootPath),
+		openFiles:        root.openFiles,
+		pinners:          []storage.Pinner{root.pins},
+		capacityEnsurers: make(map[string]storage.CapacityEnsurer),
+	}
+	entry.capacityEnsurers[localStorageName] = entry.localStorage
+	uploader, uploaders := r.newUploadService(name, conf.Storage, archives, notifier)
+	entry.uploader = uploader
+	// uploads are stopped on CleanUp before the state store is closed.
+	r.runBackground(func(ctx context.Context) {
+		<-ctx.Done()
+		uploader.Close()
+	})
+	for destinationName, destinationUploader := range uploaders {
+		if pinner, ok := destinationUploader.(storage.Pinner); ok {
+			entry.pinners = append(entry.pinners, pinner)
+		}
+		if ensurer, ok := destinationUploader.(storage.CapacityEnsurer); ok {
+			entry.capacityEnsurers[destinationName] = ensurer
+		}
+	}
+	return entry
+}
+
+// newUploadService creates upload service fanning out to all destinations of the entry,
+// along with the uploaders of destinations by name.
+// The inline destination keeps using the queue named by the entry, so that its pending jobs are resumed.
+func (r *Registry) newUploadService(
+	name string,
+	conf config.Storage,
+	archives *upload.Archives,
+	notifier notification.Service,
+) (upload.Service, map[string]upload.Uploader) {
+	uploaders := make(map[string]upload.Uploader)
+	newService := func(
+		queueName, destinationName string,
+		backend config.Backend,
+	) func(notification.Service) upload.Service {
+		return func(notifier notification.Service) upload.Service {
+			uploader, timeout := r.newUploader(backend, conf.RootPath)
+			uploaders[destinationName] = uploader
+			return upload.NewService(
+				r.logger,
+				upload.NewQueue(r.store, queueName, r.finishedTTL()),
+				uploader,
+				timeout,
+				upload.NewRetryPolicy(conf.Retry),
+				int(r.workerConcurrency(conf.Workers)),
+				r.workerOrdering(conf.Workers),
+				notifier,
+			)
+		}
+	}
+
+	onArchived := localdrive.NewOnArchived(r.logger, conf.RootPath, conf.AfterUpload, conf.UploadedPath)
+	if len(conf.Destinations) == 0 {
+		return upload.NewFanOut(r.logger, archives, notifier, []upload.Destination{{
+			Required:   true,
+			NewService: newService(name, inlineDestinationName, conf.Backend),
+		}}, onArchived), uploaders
+	}
+	destinations := make([]upload.Destination, 0, len(conf.Destinations))
+	for _, destination := range conf.Destinations {
+		destinations = append(destinations, upload.Destination{
+			Name:       destination.Name,
+			Required:   !destination.Optional,
+			NewService: newService(name+"/"+destination.Name, destination.Name, destination.Backend),
+		})
+	}
+	return upload.NewFanOut(r.logger, archives, notifier, destinations, onArchived), uploaders
+}
+
+// newUploader creates the uploader of configured backend, with its upload timeout.
+// Dry run configured globally applies to all backends.
+func (r *Registry) newUploader(conf config.Backend, rootPath string) (upload.Uploader, time.Duration) {
+	if r.conf.DryRun {
+		conf.EnableDryRun()
+	}
+	switch {
+	case conf.S3 != nil:
+		return s3.NewUploader(r.logger, conf.S3, rootPath), conf.S3.Timeout
+	case conf.WebDAV != nil:
+		return webdav.NewUploader(r.logger, conf.WebDAV, rootPath), conf.WebDAV.Timeout
+	case conf.SFTP != nil:
+		return sftp.NewUploader(r.logger, conf.SFTP, rootPath), conf.SFTP.Timeout
+	case conf.LocalMirror != nil:
+		return localmirror.NewUploader(r.logger, conf.LocalMirror, rootPath), conf.LocalMirror.Timeout
+	default:
+		uploader := gdrive.NewUploader(r.logger, conf.GoogleDrive, rootPath)
+		r.runBackground(uploader.PurgeTrash)
+		return uploader, conf.GoogleDrive.Timeout
+	}
+}
+
+// runBackground runs task in background until CleanUp.
+func (r *Registry) runBackground(task func(context.Context)) {
+	r.background.Add(1)
+	go func() {
+		defer r.background.Done()
+		task(r.ctx)
+	}()
+}
+
+// workerConcurrency returns concurrency of the entry, falling back to global configuration.
+func (r *Registry) workerConcurrency(conf config.Workers) uint {
+	if conf.Concurrency > 0 {
+		return conf.Concurrency
+	}
+	return r.conf.Workers.Concurrency
+}
+
+// workerOrdering returns ordering of the entry, falling back to global configuration.
+func (r *Registry) workerOrdering(conf config.Workers) upload.Ordering {
+	if conf.Ordering != "" {
+		return upload.Ordering(conf.Ordering)
+	}
+	if r.conf.Workers.Ordering != "" {
+		return upload.Ordering(r.conf.Workers.Ordering)
 	}
+	return upload.OrderingFIFO
 }
 
 func (s *serviceRegistry) GetNotifier(roomID uint64) notification.Service {
 	return s.getServiceEntry(roomID).notifier
 }
 
-func (s *serviceRegistry) GetLocalStorage(roomID uint64) storage.Cleaner {
+func (s *serviceRegistry) GetLocalStorage(roomID uint64) storage.TargetEnsurer {
 	return s.getServiceEntry(roomID).localStorage
 }
 
+func (s *serviceRegistry) GetLocalReserve(roomID uint64) *localdrive.Reserve {
+	return s.getServiceEntry(roomID).localReserve
+}
+
+func (s *serviceRegistry) GetOpenFiles(roomID uint64) *localdrive.OpenFiles {
+	return s.getServiceEntry(roomID).openFiles
+}
+
 func (s *serviceRegistry) GetUploader(roomID uint64) upload.Service {
 	return s.getServiceEntry(roomID).uploader
 }
 
+func (s *serviceRegistry) GetPinners(roomID uint64) []storage.Pinner {
+	return s.getServiceEntry(roomID).pinners
+}
+
+func (s *serviceRegistry) GetCapacityEnsurers(roomID uint64) map[string]storage.CapacityEnsurer {
+	return s.getServiceEntry(roomID).capacityEnsurers
+}
+
 func (s *serviceRegistry) getServiceEntry(roomID uint64) *serviceEntry {
 	if entry, ok := s.mapping[roomID]; ok {
 		return entry
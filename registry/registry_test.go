@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/config"
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+	"github.com/ayumi-otosaka-314/brec-pp/upload"
+)
+
+func TestRegistry_NewServiceRegistry_sharedRoot(t *testing.T) {
+	rootPath := t.TempDir()
+	newEntry := func() config.ServiceEntry {
+		return config.ServiceEntry{
+			Discord: config.Discord{WebhookURL: "https://discord.com/api/webhooks/test"},
+			Storage: config.Storage{
+				RootPath: rootPath,
+				Backend:  config.Backend{LocalMirror: &config.LocalMirror{Timeout: time.Minute, Path: t.TempDir()}},
+				Local:    config.LocalStorage{Quota: 1},
+			},
+		}
+	}
+	r, err := New(&config.Root{
+		State: config.State{Directory: t.TempDir()},
+		Services: config.ServiceRegistry{
+			Default: newEntry(),
+			Streamers: []config.StreamerServiceEntry{
+				{RoomID: 1001, ServiceEntry: newEntry()},
+				{RoomID: 1002, ServiceEntry: newEntry()},
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer r.CleanUp()
+	registry := r.NewServiceRegistry()
+
+	for _, relativePath := range []string{
+		"1001-a/old.flv", "1001-a/pinned.flv", "1002-b/recording.flv", "1002-b/pending.flv",
+	} {
+		require.NoError(t, os.MkdirAll(path.Join(rootPath, path.Dir(relativePath)), 0755))
+		require.NoError(t, os.WriteFile(path.Join(rootPath, relativePath), []byte("content"), 0644))
+	}
+	require.NoError(t, registry.GetOpenFiles(1002).Open("1002-b/recording.flv"))
+	require.NoError(t, upload.NewArchives(r.store, "1002", time.Hour).Put(&upload.Archive{
+		ID:    "1002-b/pending.flv",
+		Event: &brec.EventDataFileClose{RelativePath: "1002-b/pending.flv"},
+	}))
+
+	// pinned without room, i.e. by the default entry.
+	for _, pinner := range registry.GetPinners(0) {
+		require.NoError(t, pinner.SetPinned(context.Background(), storage.Pin{RelativePath: "1001-a/pinned.flv"}, true))
+	}
+
+	// cleaning for room 1001 should protect recordings of other entries under the same root.
+	err = registry.GetLocalStorage(1001).EnsureCapacity(context.Background(), 1)
+	assert.ErrorIs(t, err, storage.ErrCapacityNotEnsured)
+	assert.NoFileExists(t, path.Join(rootPath, "1001-a/old.flv"))
+	assert.FileExists(t, path.Join(rootPath, "1001-a/pinned.flv"), "recording pinned should not be removed")
+	assert.FileExists(t, path.Join(rootPath, "1002-b/recording.flv"), "recording being written should not be removed")
+	assert.FileExists(t, path.Join(rootPath, "1002-b/pending.flv"), "recording pending upload should not be removed")
+}
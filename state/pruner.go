@@ -0,0 +1,50 @@
+package state
+
+import (
+	"sync"
+	"time"
+)
+
+// Pruner deletes expired values of a bucket in the store, at most once per interval.
+type Pruner struct {
+	store    *Store
+	bucket   string
+	interval time.Duration
+	// expired reports if the raw value is expired.
+	expired func(raw []byte) (bool, error)
+
+	// mu guards pruning.
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+func NewPruner(store *Store, bucket string, interval time.Duration, expired func(raw []byte) (bool, error)) *Pruner {
+	return &Pruner{store: store, bucket: bucket, interval: interval, expired: expired}
+}
+
+// Prune deletes expired values, unless pruned already within interval.
+func (p *Pruner) Prune() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Since(p.lastPruned) < p.interval {
+		return nil
+	}
+	expired := make([]string, 0)
+	if err := p.store.ForEach(p.bucket, func(key string, raw []byte) error {
+		ok, err := p.expired(raw)
+		if ok {
+			expired = append(expired, key)
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+	for _, key := range expired {
+		if err := p.store.Delete(p.bucket, key); err != nil {
+			return err
+		}
+	}
+	p.lastPruned = time.Now()
+	return nil
+}
@@ -0,0 +1,92 @@
+package state
+
+import (
+	"os"
+	"path"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+const fileName = "brec-pp.db"
+
+// Store is a durable key-value store persisted under the configured state directory.
+// Values are organized in named buckets and serialized as JSON.
+type Store struct {
+	db *bbolt.DB
+}
+
+func Open(directory string) (*Store, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, errors.Wrap(err, "unable to create state directory")
+	}
+	db, err := bbolt.Open(path.Join(directory, fileName), 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open state database")
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Put stores value under key in bucket, overwriting any existing value.
+func (s *Store) Put(bucket, key string, value any) error {
+	raw, err := jsoniter.Marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "error marshalling state value")
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return errors.Wrap(err, "unable to create state bucket")
+		}
+		return b.Put([]byte(key), raw)
+	})
+}
+
+// Get loads value stored under key in bucket.
+// It returns false if no such value exists.
+func (s *Store) Get(bucket, key string, value any) (bool, error) {
+	var raw []byte
+	if err := s.db.View(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket([]byte(bucket)); b != nil {
+			if v := b.Get([]byte(key)); v != nil {
+				raw = append(raw, v...)
+			}
+		}
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	if raw == nil {
+		return false, nil
+	}
+	return true, errors.Wrap(jsoniter.Unmarshal(raw, value), "error unmarshalling state value")
+}
+
+func (s *Store) Delete(bucket, key string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket([]byte(bucket)); b != nil {
+			return b.Delete([]byte(key))
+		}
+		return nil
+	})
+}
+
+// ForEach calls fn with every key and raw value in bucket, ordered by key.
+// The raw value is only valid during the call to fn.
+func (s *Store) ForEach(bucket string, fn func(key string, raw []byte) error) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+}
@@ -0,0 +1,50 @@
+package state
+
+import (
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+// TTLSet is a set of keys persisted in a bucket of the store, each forgotten once ttl has passed since added.
+// Expired keys are pruned while adding, at most once per ttl.
+type TTLSet struct {
+	store  *Store
+	bucket string
+	ttl    time.Duration
+	pruner *Pruner
+}
+
+func NewTTLSet(store *Store, bucket string, ttl time.Duration) *TTLSet {
+	return &TTLSet{
+		store:  store,
+		bucket: bucket,
+		ttl:    ttl,
+		pruner: NewPruner(store, bucket, ttl, func(raw []byte) (bool, error) {
+			var addedAt time.Time
+			if err := jsoniter.Unmarshal(raw, &addedAt); err != nil {
+				return false, errors.Wrap(err, "error unmarshalling time key added")
+			}
+			return time.Since(addedAt) >= ttl, nil
+		}),
+	}
+}
+
+// Contains reports if key has been added to the set within ttl.
+func (s *TTLSet) Contains(key string) (bool, error) {
+	var addedAt time.Time
+	found, err := s.store.Get(s.bucket, key, &addedAt)
+	if err != nil {
+		return false, err
+	}
+	return found && time.Since(addedAt) < s.ttl, nil
+}
+
+// Add adds key to the set, or renews it if added already.
+func (s *TTLSet) Add(key string) error {
+	if err := s.pruner.Prune(); err != nil {
+		return err
+	}
+	return s.store.Put(s.bucket, key, time.Now())
+}
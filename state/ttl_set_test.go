@@ -0,0 +1,38 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTLSet(t *testing.T) {
+	store, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	s := NewTTLSet(store, "test", time.Hour)
+	contains, err := s.Contains("a")
+	require.NoError(t, err)
+	assert.False(t, contains)
+	require.NoError(t, s.Add("a"))
+	contains, err = s.Contains("a")
+	require.NoError(t, err)
+	assert.True(t, contains)
+
+	// keys persisted should be remembered by another set of the same bucket, and pruned once expired.
+	require.NoError(t, store.Put("test", "b", time.Now().Add(-2*time.Hour)))
+	s = NewTTLSet(store, "test", time.Hour)
+	contains, err = s.Contains("b")
+	require.NoError(t, err)
+	assert.False(t, contains, "expired key should not be contained")
+	require.NoError(t, s.Add("c"))
+	found, err := store.Get("test", "b", &time.Time{})
+	require.NoError(t, err)
+	assert.False(t, found, "expired key should be pruned")
+	contains, err = s.Contains("a")
+	require.NoError(t, err)
+	assert.True(t, contains)
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// MD5Checksum returns the hex encoded MD5 checksum of the file at filePath.
+func MD5Checksum(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to open file for checksum")
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", errors.Wrap(err, "unable to read file for checksum")
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// VerifySize returns error if size of the uploaded file differs from the local file.
+func VerifySize(localSize, uploadedSize int64) error {
+	if localSize != uploadedSize {
+		return errors.Errorf("uploaded file size mismatch: local [%d], uploaded [%d]", localSize, uploadedSize)
+	}
+	return nil
+}
+
+// VerifyChecksum returns error if checksum of the uploaded file differs from the local file.
+func VerifyChecksum(localChecksum, uploadedChecksum string) error {
+	if localChecksum != uploadedChecksum {
+		return errors.Errorf("uploaded file checksum mismatch: local [%s], uploaded [%s]", localChecksum, uploadedChecksum)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"math"
 
 	"github.com/pkg/errors"
 )
@@ -19,26 +20,78 @@ type Cleaner interface {
 // It would return the space cleared in byte count, and error if any during cleaning.
 type DoRemove func() (uint64, error)
 
+// ErrCapacityNotEnsured is returned by EnsureCapacity if removables are exhausted before reaching target capacity,
+// e.g. remaining objects are protected from removal.
+var ErrCapacityNotEnsured = errors.New("capacity not ensured")
+
+// Target is the available capacity to be ensured in multiple dimensions.
+type Target struct {
+	Bytes uint64
+	// Files is the count of files which could be created, e.g. free inodes of the file system.
+	Files uint64
+}
+
+// FileCounter is a Service reporting available count of files besides bytes.
+type FileCounter interface {
+	GetAvailableFiles() (uint64, error)
+}
+
+// EnsureCapacity removes removables of cleaner until available capacity reaches targetCapacity in bytes.
 func EnsureCapacity(ctx context.Context, targetCapacity uint64, cleaner Cleaner) error {
+	return EnsureTarget(ctx, Target{Bytes: targetCapacity}, cleaner)
+}
+
+// EnsureTarget removes removables of cleaner until available capacity reaches target in all dimensions;
+// count of files is only ensured if cleaner is a FileCounter.
+// Rules of cleaner are enforced beforehand if it is an Enforcer.
+// In dry run, bytes and files of removals planned are considered available, as nothing is actually removed.
+func EnsureTarget(ctx context.Context, target Target, cleaner Cleaner) error {
+	if enforcer, ok := cleaner.(Enforcer); ok {
+		if err := enforcer.Enforce(ctx); err != nil {
+			return errors.Wrap(err, "unable to enforce retention policy")
+		}
+	}
+
 	const allowedIterations = 5
-    for range allowedIterations {
-		availCapacity, err := cleaner.GetAvailableCapacity()
+	for range allowedIterations {
+		available, err := availableCapacity(ctx, target, cleaner)
 		if err != nil {
-			return errors.Wrap(err, "unable to check available bytes")
+			return err
 		}
-
-		if availCapacity >= targetCapacity {
+		if available.Bytes >= target.Bytes && available.Files >= target.Files {
 			return nil
 		}
 
-		if err = doEnsureCapacity(ctx, targetCapacity-availCapacity, cleaner); err != nil {
+		if err = doEnsureCapacity(ctx, target, available, cleaner); err != nil {
 			return err
 		}
 	}
-	return errors.Errorf("unable to ensure capacity in [%d] iterations", allowedIterations)
+	return errors.Wrapf(ErrCapacityNotEnsured, "unable to ensure capacity in [%d] iterations", allowedIterations)
 }
 
-func doEnsureCapacity(parentCtx context.Context, cleanTarget uint64, cleaner Cleaner) error {
+// availableCapacity returns available capacity of cleaner in dimensions of target,
+// which is considered reached for dimensions not reported by cleaner.
+func availableCapacity(ctx context.Context, target Target, cleaner Cleaner) (Target, error) {
+	availBytes, err := cleaner.GetAvailableCapacity()
+	if err != nil {
+		return Target{}, errors.Wrap(err, "unable to check available bytes")
+	}
+	available := Target{Bytes: availBytes, Files: target.Files}
+	if counter, ok := cleaner.(FileCounter); ok && target.Files > 0 {
+		if available.Files, err = counter.GetAvailableFiles(); err != nil {
+			return Target{}, errors.Wrap(err, "unable to check available files")
+		}
+	}
+
+	if plan := planOf(ctx); plan != nil {
+		available.Bytes += plan.Reclaimed()
+		// files could be unlimited, which should not overflow.
+		available.Files += min(uint64(len(plan.Removals())), math.MaxUint64-available.Files)
+	}
+	return available, nil
+}
+
+func doEnsureCapacity(parentCtx context.Context, target, available Target, cleaner Cleaner) error {
 	ctx, cancel := context.WithCancel(parentCtx)
 	defer cancel()
 
@@ -47,17 +100,27 @@ func doEnsureCapacity(parentCtx context.Context, cleanTarget uint64, cleaner Cle
 		return errors.Wrap(err, "unable to get removables")
 	}
 
+	// check before performing subtraction, to prevent overflow of uint64.
+	var cleanTarget uint64
+	if target.Bytes > available.Bytes {
+		cleanTarget = target.Bytes - available.Bytes
+	}
 	for remove := range removables {
 		clearedSize, err := remove()
 		if err != nil {
 			return errors.Wrap(err, "error removing object; stopping")
 		}
+		cleanTarget -= min(clearedSize, cleanTarget)
 
-		// check before performing subtraction, to prevent overflow of uint64.
-		if clearedSize >= cleanTarget {
+		// removables could contain several files each, so files available are checked again instead.
+		if available.Files < target.Files {
+			if available, err = availableCapacity(ctx, target, cleaner); err != nil {
+				return err
+			}
+		}
+		if cleanTarget == 0 && available.Files >= target.Files {
 			return nil
 		}
-		cleanTarget -= clearedSize
 	}
 	return nil
 }
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// fakeFileLister is a fakeLister whose entries are directories of files, counting files available.
+type fakeFileLister struct {
+	*fakeLister
+	filesPerEntry uint64
+	files         uint64
+}
+
+func (l *fakeFileLister) GetAvailableFiles() (uint64, error) {
+	return l.files + l.filesPerEntry*uint64(5-len(l.entries)), nil
+}
+
+func TestEnsureTarget(t *testing.T) {
+	t.Parallel()
+
+	newLister := func() *fakeFileLister {
+		return &fakeFileLister{
+			fakeLister: newFakeLister(
+				&Entry{Path: "a/1", Size: 1},
+				&Entry{Path: "a/2", Size: 2},
+				&Entry{Path: "a/3", Size: 3},
+				&Entry{Path: "a/4", Size: 4},
+				&Entry{Path: "a/5", Size: 5},
+			),
+			filesPerEntry: 3,
+		}
+	}
+
+	lister := newLister()
+	require.NoError(t, EnsureTarget(context.Background(), Target{Bytes: 1, Files: 7}, lister))
+	assert.Equal(t, []string{"a/4", "a/5"}, lister.paths(), "entries should be removed until files are available")
+
+	lister = newLister()
+	require.NoError(t, EnsureTarget(context.Background(), Target{Bytes: 6, Files: 1}, lister))
+	assert.Equal(t, []string{"a/4", "a/5"}, lister.paths(), "entries should be removed until bytes are available")
+
+	lister = newLister()
+	plan := NewPlan(zaptest.NewLogger(t))
+	require.NoError(t, EnsureTarget(WithDryRun(context.Background(), plan), Target{Files: 2}, lister))
+	assert.Len(t, plan.Removals(), 2, "files of removals planned should be considered available")
+	assert.Len(t, lister.paths(), 5)
+
+	lister = newLister()
+	assert.ErrorIs(t, EnsureTarget(context.Background(), Target{Files: 100}, lister), ErrCapacityNotEnsured)
+
+	require.NoError(t, EnsureCapacity(context.Background(), 0, newFakeLister(&Entry{Path: "a/1", Size: 1})),
+		"files should not be ensured without target")
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Plan records removals planned in dry run, instead of actually removing.
+type Plan struct {
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	removals []PlannedRemoval
+	planned  map[string]struct{}
+}
+
+// PlannedRemoval is a removable which would be removed if not in dry run.
+type PlannedRemoval struct {
+	Item string `json:"item"`
+	Size uint64 `json:"size"`
+}
+
+func NewPlan(logger *zap.Logger) *Plan {
+	return &Plan{logger: logger, planned: make(map[string]struct{})}
+}
+
+// add records removal of item, and returns false if it has been planned already.
+func (p *Plan) add(item string, size uint64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.planned[item]; ok {
+		return false
+	}
+	p.planned[item] = struct{}{}
+	p.removals = append(p.removals, PlannedRemoval{Item: item, Size: size})
+	p.logger.Info("dry run: would remove", zap.String("item", item), zap.Uint64("size", size))
+	return true
+}
+
+// Removals returns removals planned in order.
+func (p *Plan) Removals() []PlannedRemoval {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]PlannedRemoval(nil), p.removals...)
+}
+
+// Reclaimed returns total bytes of removals planned.
+func (p *Plan) Reclaimed() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var reclaimed uint64
+	for _, removal := range p.removals {
+		reclaimed += removal.Size
+	}
+	return reclaimed
+}
+
+type planKey struct{}
+
+// WithDryRun makes cleaning within ctx a dry run, which records removals to plan instead.
+func WithDryRun(ctx context.Context, plan *Plan) context.Context {
+	return context.WithValue(ctx, planKey{}, plan)
+}
+
+// DryRun makes cleaning within ctx a dry run logged by logger if enabled, unless it is in dry run already.
+func DryRun(ctx context.Context, logger *zap.Logger, enabled bool) context.Context {
+	if !enabled || IsDryRun(ctx) {
+		return ctx
+	}
+	return WithDryRun(ctx, NewPlan(logger))
+}
+
+// IsDryRun reports if cleaning within ctx is a dry run.
+func IsDryRun(ctx context.Context) bool {
+	return planOf(ctx) != nil
+}
+
+func planOf(ctx context.Context) *Plan {
+	plan, _ := ctx.Value(planKey{}).(*Plan)
+	return plan
+}
+
+// Remove removes item of size by remove, or records it to plan if ctx is in dry run.
+// Cleaners should remove via Remove in DoRemove, so that dry run is honored.
+// Items planned already are reported as zero bytes cleared, as they are listed again by later iterations.
+func Remove(ctx context.Context, item string, size uint64, remove func() error) (uint64, error) {
+	if plan := planOf(ctx); plan != nil {
+		if !plan.add(item, size) {
+			return 0, nil
+		}
+		return size, nil
+	}
+	if err := remove(); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// CapacityEnsurer ensures capacity of its own storage, e.g. an upload destination.
+type CapacityEnsurer interface {
+	EnsureCapacity(ctx context.Context, targetCapacity uint64) error
+}
+
+// TargetEnsurer is a CapacityEnsurer able to ensure capacity in multiple dimensions at once.
+type TargetEnsurer interface {
+	CapacityEnsurer
+	EnsureTarget(ctx context.Context, target Target) error
+}
+
+type capacityEnsurer struct {
+	logger  *zap.Logger
+	cleaner Cleaner
+	dryRun  bool
+}
+
+// NewCapacityEnsurer creates TargetEnsurer of cleaner, whose cleaning is always a dry run if dryRun is true.
+func NewCapacityEnsurer(logger *zap.Logger, cleaner Cleaner, dryRun bool) TargetEnsurer {
+	return &capacityEnsurer{logger: logger, cleaner: cleaner, dryRun: dryRun}
+}
+
+func (e *capacityEnsurer) EnsureCapacity(ctx context.Context, targetCapacity uint64) error {
+	return e.EnsureTarget(ctx, Target{Bytes: targetCapacity})
+}
+
+func (e *capacityEnsurer) EnsureTarget(ctx context.Context, target Target) error {
+	return EnsureTarget(DryRun(ctx, e.logger, e.dryRun), target, e.cleaner)
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ayumi-otosaka-314/brec-pp/config"
+)
+
+func TestEnsureCapacity_dryRun(t *testing.T) {
+	lister := newFakeLister(
+		&Entry{Path: "a/1.flv", Size: 1, LastModified: daysAgo(4)},
+		&Entry{Path: "a/2.flv", Size: 2, LastModified: daysAgo(3)},
+		&Entry{Path: "a/3.flv", Size: 3, LastModified: daysAgo(2)},
+		&Entry{Path: "a/4.flv", Size: 4, LastModified: daysAgo(1)},
+	)
+	logger := zaptest.NewLogger(t)
+	cleaner := NewRetention(logger, lister, &config.Retention{MaxAge: 80 * time.Hour})
+
+	plan := NewPlan(logger)
+	require.NoError(t, EnsureCapacity(WithDryRun(context.Background(), plan), 5, cleaner))
+	assert.Len(t, lister.entries, 4, "nothing should be removed in dry run")
+	assert.Equal(t, []PlannedRemoval{
+		{Item: "a/1.flv", Size: 1}, // by retention policy
+		{Item: "a/2.flv", Size: 2},
+		{Item: "a/3.flv", Size: 3},
+	}, plan.Removals())
+	assert.Equal(t, uint64(6), plan.Reclaimed())
+
+	plan = NewPlan(logger)
+	require.Error(t, EnsureCapacity(WithDryRun(context.Background(), plan), 20, cleaner))
+	assert.Equal(t, uint64(10), plan.Reclaimed(), "each entry should be planned once across iterations")
+
+	ensurer := NewCapacityEnsurer(logger, lister, true)
+	require.NoError(t, ensurer.EnsureCapacity(context.Background(), 10))
+	assert.Len(t, lister.entries, 4, "nothing should be removed by ensurer in dry run")
+}
@@ -1,8 +1,12 @@
 package gdrive
 
 import (
+	"container/heap"
 	"context"
 	"fmt"
+	"path"
+	"sort"
+	"time"
 
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
@@ -11,48 +15,136 @@ import (
 	"github.com/ayumi-otosaka-314/brec-pp/storage"
 )
 
+// cleanerPageSize is the count of files listed per request when looking for removables.
+const cleanerPageSize = 100
+
 // cleaner implements storage.Cleaner.
 // It is used to clear old recordings on Google Drive to ensure capacity before uploading.
+// Recordings in all folders nested under the parent folder are removed from the oldest except those pinned,
+// and folders emptied by removal are removed as well.
 type cleaner struct {
 	logger         *zap.Logger
 	driveService   *drive.Service
 	parentFolderID string
+	// sharedDriveID is the shared drive of parent folder, whose capacity is quota minus size of files under parent folder.
+	// Capacity of My Drive is used if it is empty.
+	sharedDriveID string
+	quota         uint64
+	// trashGracePeriod is the period to keep removed files in trash before purged;
+	// files are deleted permanently on removal if it is zero.
+	trashGracePeriod time.Duration
+	// purgeEarly allows purging files in trash within grace period, if the capacity is needed for upload.
+	purgeEarly bool
+	// folders caches folder IDs for uploads; it is invalidated once a folder is removed. It could be nil.
+	folders *folders
 }
 
 func (c *cleaner) GetAvailableCapacity() (uint64, error) {
-	about, err := c.driveService.About.Get().Fields("storageQuota").Do()
+	ctx := context.Background()
+	available, err := c.getFreeCapacity(ctx)
+	if err != nil || c.trashGracePeriod == 0 {
+		return available, err
+	}
+
+	// files trashed are still counted in usage, but considered available as they would be purged once needed.
+	trashed, err := c.listTrashed(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range trashed {
+		available += uint64(file.Size)
+	}
+	return available, nil
+}
+
+// getFreeCapacity returns the capacity actually available now, where files in trash are counted as used.
+func (c *cleaner) getFreeCapacity(ctx context.Context) (uint64, error) {
+	if c.sharedDriveID != "" {
+		return c.getSharedDriveCapacity(ctx)
+	}
+	about, err := c.driveService.About.Get().Fields("storageQuota").Context(ctx).Do()
 	if err != nil {
 		return 0, errors.Wrap(err, "unable to get gdrive usage")
 	}
 	return uint64(about.StorageQuota.Limit - about.StorageQuota.Usage), nil
 }
 
-func (c *cleaner) GetRemovables(ctx context.Context) (<-chan storage.DoRemove, error) {
+// getSharedDriveCapacity returns quota minus total size of files under parent folder, including those in trash.
+func (c *cleaner) getSharedDriveCapacity(ctx context.Context) (uint64, error) {
+	parents, err := c.listFolders(ctx)
+	if err != nil {
+		return 0, err
+	}
 
-	r, err := c.driveService.Files.
-		List().
-		Q(fmt.Sprintf(
-			"mimeType != 'application/vnd.google-apps.folder' and '%s' in parents",
-			c.parentFolderID,
-		)).
-		OrderBy("modifiedTime").
-		PageSize(10).
-		Fields("files(id, name, size)").
-		Do()
+	var usage uint64
+	for folderID := range parents {
+		if err = listFiles(c.driveService, c.sharedDriveID).
+			Q(fmt.Sprintf("mimeType != '%s' and '%s' in parents", folderMimeType, folderID)).
+			Fields("nextPageToken, files(size)").
+			Pages(ctx, func(r *drive.FileList) error {
+				for _, file := range r.Files {
+					usage += uint64(file.Size)
+				}
+				return nil
+			}); err != nil {
+			return 0, errors.Wrap(err, "unable to get shared drive usage")
+		}
+	}
+	if usage >= c.quota {
+		return 0, nil
+	}
+	return c.quota - usage, nil
+}
+
+func (c *cleaner) GetRemovables(ctx context.Context) (<-chan storage.DoRemove, error) {
+	parents, err := c.listFolders(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	// files of each folder are listed page by page, and merged so that the oldest among all folders is removed first.
+	pending := &folderFilesHeap{}
+	for folderID := range parents {
+		f := &folderFiles{folderID: folderID}
+		if err = c.nextPage(ctx, f); err != nil {
+			return nil, err
+		}
+		if len(f.files) > 0 {
+			heap.Push(pending, f)
+		}
+	}
+
 	result := make(chan storage.DoRemove)
 	go func() {
 		defer close(result)
 
-		for _, file := range r.Files {
-			file := file
+		for pending.Len() > 0 {
+			f := (*pending)[0]
+			file := f.files[0]
+			if f.files = f.files[1:]; len(f.files) == 0 {
+				if err := c.nextPage(ctx, f); err != nil {
+					c.logger.Error("error listing files on google drive", zap.Error(err))
+					return
+				}
+			}
+			// the folder might be emptied once its last file is removed.
+			last := len(f.files) == 0
+			if last {
+				heap.Pop(pending)
+			} else {
+				heap.Fix(pending, 0)
+			}
+
 			doRemove := func() (uint64, error) {
-				c.logger.Debug("deleting file from google drive",
-					zap.String("name", file.Name), zap.String("fileID", file.Id), zap.Int64("size", file.Size))
-				return uint64(file.Size), c.driveService.Files.Delete(file.Id).Do()
+				return storage.Remove(ctx, file.Name, uint64(file.Size), func() error {
+					if err := c.remove(ctx, file); err != nil {
+						return err
+					}
+					if last {
+						c.removeEmptyFolders(ctx, f.folderID, parents)
+					}
+					return nil
+				})
 			}
 			select {
 			case result <- doRemove:
@@ -65,3 +157,180 @@ func (c *cleaner) GetRemovables(ctx context.Context) (<-chan storage.DoRemove, e
 	}()
 	return result, nil
 }
+
+// listFolders returns the parent folder and all folders nested under it, mapped to their parent folder IDs.
+func (c *cleaner) listFolders(ctx context.Context) (map[string]string, error) {
+	parents, _, err := c.listFolderPaths(ctx)
+	return parents, err
+}
+
+// listFolderPaths returns the parent folder and all folders nested under it, mapped to their parent folder IDs,
+// and to their paths relative to the parent folder.
+func (c *cleaner) listFolderPaths(ctx context.Context) (map[string]string, map[string]string, error) {
+	parents := map[string]string{c.parentFolderID: ""}
+	paths := map[string]string{c.parentFolderID: ""}
+	for queue := []string{c.parentFolderID}; len(queue) > 0; queue = queue[1:] {
+		folderID := queue[0]
+		if err := listFiles(c.driveService, c.sharedDriveID).
+			Q(fmt.Sprintf("mimeType = '%s' and '%s' in parents and trashed = false", folderMimeType, folderID)).
+			Fields("nextPageToken, files(id, name)").
+			Context(ctx).
+			Pages(ctx, func(r *drive.FileList) error {
+				for _, folder := range r.Files {
+					parents[folder.Id] = folderID
+					paths[folder.Id] = path.Join(paths[folderID], folder.Name)
+					queue = append(queue, folder.Id)
+				}
+				return nil
+			}); err != nil {
+			return nil, nil, errors.Wrap(err, "unable to list gdrive folders")
+		}
+	}
+	return parents, paths, nil
+}
+
+// ListEntries returns all files under the parent folder except those pinned, the oldest first.
+// Sessions of files are recorded in their app properties on upload.
+func (c *cleaner) ListEntries(ctx context.Context) ([]*storage.Entry, error) {
+	parents, paths, err := c.listFolderPaths(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*storage.Entry, 0)
+	for folderID := range parents {
+		if err = listFiles(c.driveService, c.sharedDriveID).
+			Q(fmt.Sprintf("mimeType != '%s' and '%s' in parents and trashed = false", folderMimeType, folderID)).
+			PageSize(cleanerPageSize).
+			Fields("nextPageToken, files(id, name, size, modifiedTime, appProperties)").
+			Pages(ctx, func(r *drive.FileList) error {
+				for _, file := range r.Files {
+					if isPinned(file) {
+						continue
+					}
+					modifiedTime, err := time.Parse(time.RFC3339, file.ModifiedTime)
+					if err != nil {
+						return errors.Wrap(err, "malformed modified time of gdrive file")
+					}
+					entryPath := path.Join(paths[folderID], file.Name)
+					result = append(result, &storage.Entry{
+						Path:         entryPath,
+						Size:         uint64(file.Size),
+						LastModified: modifiedTime,
+						Session:      file.AppProperties[sessionIDProperty],
+						Remove: func() (uint64, error) {
+							return storage.Remove(ctx, entryPath, uint64(file.Size), func() error {
+								if err := c.remove(ctx, file); err != nil {
+									return err
+								}
+								c.removeEmptyFolders(ctx, folderID, parents)
+								return nil
+							})
+						},
+					})
+				}
+				return nil
+			}); err != nil {
+			return nil, errors.Wrap(err, "unable to list gdrive files")
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].LastModified.Before(result[j].LastModified)
+	})
+	return result, nil
+}
+
+// nextPage lists the next page of files in the folder not pinned, if there is any.
+// Pages are listed until some files are found, or no more pages are left.
+func (c *cleaner) nextPage(ctx context.Context, f *folderFiles) error {
+	f.files = nil
+	for len(f.files) == 0 && !(f.listed && f.nextPageToken == "") {
+		r, err := listFiles(c.driveService, c.sharedDriveID).
+			Q(fmt.Sprintf("mimeType != '%s' and '%s' in parents and trashed = false", folderMimeType, f.folderID)).
+			OrderBy("modifiedTime").
+			PageSize(cleanerPageSize).
+			PageToken(f.nextPageToken).
+			Fields("nextPageToken, files(id, name, size, modifiedTime, appProperties)").
+			Context(ctx).
+			Do()
+		if err != nil {
+			return errors.Wrap(err, "unable to list gdrive files")
+		}
+		f.listed = true
+		f.nextPageToken = r.NextPageToken
+		for _, file := range r.Files {
+			if !isPinned(file) {
+				f.files = append(f.files, file)
+			}
+		}
+	}
+	return nil
+}
+
+// remove deletes the file, or moves it to trash if trash grace period is configured.
+func (c *cleaner) remove(ctx context.Context, file *drive.File) error {
+	if c.trashGracePeriod > 0 {
+		return c.trash(ctx, file)
+	}
+	c.logger.Debug("deleting file from google drive",
+		zap.String("name", file.Name), zap.String("fileID", file.Id), zap.Int64("size", file.Size))
+	return c.driveService.Files.Delete(file.Id).SupportsAllDrives(true).Context(ctx).Do()
+}
+
+// removeEmptyFolders removes folderID and then its ancestors while they are empty, except the parent folder.
+// Folders containing files in trash are not empty, as removing them would purge those files.
+// Errors are only logged, as the removal of files has succeeded.
+func (c *cleaner) removeEmptyFolders(ctx context.Context, folderID string, parents map[string]string) {
+	for ; folderID != c.parentFolderID && folderID != ""; folderID = parents[folderID] {
+		r, err := listFiles(c.driveService, c.sharedDriveID).
+			Q(fmt.Sprintf("'%s' in parents", folderID)).
+			PageSize(1).
+			Fields("files(id)").
+			Context(ctx).
+			Do()
+		if err != nil {
+			c.logger.Warn("error listing folder on google drive", zap.Error(err), zap.String("folderID", folderID))
+			return
+		}
+		if len(r.Files) > 0 {
+			return
+		}
+
+		c.logger.Debug("deleting empty folder from google drive", zap.String("folderID", folderID))
+		if err = c.driveService.Files.Delete(folderID).SupportsAllDrives(true).Context(ctx).Do(); err != nil {
+			c.logger.Warn("error deleting empty folder on google drive", zap.Error(err), zap.String("folderID", folderID))
+			return
+		}
+		if c.folders != nil {
+			c.folders.invalidate()
+		}
+	}
+}
+
+// folderFiles are the files of a folder listed but not yet removed, oldest first.
+type folderFiles struct {
+	folderID      string
+	files         []*drive.File
+	listed        bool
+	nextPageToken string
+}
+
+// folderFilesHeap orders folders by their oldest files listed; folders in the heap should not be empty.
+type folderFilesHeap []*folderFiles
+
+func (h folderFilesHeap) Len() int { return len(h) }
+
+func (h folderFilesHeap) Less(i, j int) bool {
+	return h[i].files[0].ModifiedTime < h[j].files[0].ModifiedTime
+}
+
+func (h folderFilesHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *folderFilesHeap) Push(x any) { *h = append(*h, x.(*folderFiles)) }
+
+func (h *folderFilesHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,193 @@
+package gdrive
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+)
+
+func Test_cleaner_GetRemovables(t *testing.T) {
+	fake := &fakeDrive{pageLimit: 1, files: testFiles()}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	folders := newFolders("root", "")
+	folders.cache["b/c"] = "c"
+	c := &cleaner{
+		logger:         zaptest.NewLogger(t),
+		driveService:   newTestDriveService(t, server),
+		parentFolderID: "root",
+		folders:        folders,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	removables, err := c.GetRemovables(ctx)
+	require.NoError(t, err)
+
+	sizes := make([]uint64, 0)
+	for remove := range removables {
+		size, err := remove()
+		require.NoError(t, err)
+		sizes = append(sizes, size)
+
+		if size == 3 {
+			// emptied folders should be removed up to the parent folder.
+			assert.NotContains(t, fake.files, "c")
+			assert.NotContains(t, fake.files, "b")
+			assert.Contains(t, fake.files, "a")
+			assert.Empty(t, folders.cache)
+		}
+	}
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5}, sizes, "files should be removed from the oldest")
+	assert.Empty(t, fake.files)
+}
+
+func Test_cleaner_ListEntries(t *testing.T) {
+	fake := &fakeDrive{pageLimit: 1, files: testFiles()}
+	fake.files["c1"].AppProperties = map[string]string{sessionIDProperty: "session"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	c := &cleaner{
+		logger:         zaptest.NewLogger(t),
+		driveService:   newTestDriveService(t, server),
+		parentFolderID: "root",
+	}
+
+	entries, err := c.ListEntries(context.Background())
+	require.NoError(t, err)
+	paths := make([]string, 0)
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	assert.Equal(t, []string{"a/a1.flv", "b/c/c1.flv", "b/c/c2.flv", "a/a2.flv", "r1.flv"}, paths)
+	assert.Equal(t, "session", entries[1].Session)
+
+	// emptied folders should be removed along with files.
+	for _, entry := range entries[1:3] {
+		_, err = entry.Remove()
+		require.NoError(t, err)
+	}
+	assert.NotContains(t, fake.files, "b")
+	assert.Contains(t, fake.files, "a")
+}
+
+func Test_cleaner_sharedDrive(t *testing.T) {
+	fake := &fakeDrive{pageLimit: 1, files: testFiles(), driveID: "shared"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	c := &cleaner{
+		logger:         zaptest.NewLogger(t),
+		driveService:   newTestDriveService(t, server),
+		parentFolderID: "root",
+		sharedDriveID:  "shared",
+		quota:          20,
+	}
+
+	capacity, err := c.GetAvailableCapacity()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), capacity, "capacity should be quota minus size of files under parent folder")
+
+	c.quota = 10
+	capacity, err = c.GetAvailableCapacity()
+	require.NoError(t, err)
+	assert.Zero(t, capacity)
+
+	c.quota = 20
+	require.NoError(t, storage.EnsureCapacity(context.Background(), 11, c))
+	assert.NotContains(t, fake.files, "a1")
+	assert.NotContains(t, fake.files, "c1")
+	assert.NotContains(t, fake.files, "c2")
+	assert.Contains(t, fake.files, "a2")
+}
+
+func Test_cleaner_trash(t *testing.T) {
+	fake := &fakeDrive{files: testFiles(), limit: 20}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	c := &cleaner{
+		logger:           zaptest.NewLogger(t),
+		driveService:     newTestDriveService(t, server),
+		parentFolderID:   "root",
+		trashGracePeriod: time.Hour,
+	}
+
+	// files should be trashed instead of deleted, and considered available.
+	require.NoError(t, storage.EnsureCapacity(context.Background(), 11, c))
+	for _, id := range []string{"a1", "c1", "c2"} {
+		require.Contains(t, fake.files, id)
+		assert.True(t, fake.files[id].Trashed, id)
+	}
+	assert.False(t, fake.files["a2"].Trashed)
+	capacity, err := c.GetAvailableCapacity()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(11), capacity)
+	free, err := c.getFreeCapacity(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), free, "trashed files should be counted as used")
+
+	// files within grace period should not be purged.
+	require.NoError(t, c.purgeExpired(context.Background()))
+	assert.Contains(t, fake.files, "a1")
+
+	// files should be purged from the earliest trashed to reclaim capacity.
+	fake.files["a1"].AppProperties[trashedAtProperty] = "2024-05-01T00:00:00Z"
+	require.NoError(t, c.reclaim(context.Background(), 6))
+	assert.NotContains(t, fake.files, "a1")
+	assert.Contains(t, fake.files, "c1")
+
+	// files within grace period should not be purged to reclaim capacity, unless purging early is enabled.
+	assert.ErrorIs(t, c.reclaim(context.Background(), 7), storage.ErrCapacityNotEnsured)
+	assert.Contains(t, fake.files, "c1")
+	c.purgeEarly = true
+	require.NoError(t, c.reclaim(context.Background(), 7))
+	assert.NotContains(t, fake.files, "c1")
+	assert.Contains(t, fake.files, "c2")
+
+	// files after grace period should be purged, along with folders emptied.
+	fake.files["c2"].AppProperties[trashedAtProperty] = "2024-05-01T00:00:00Z"
+	require.NoError(t, c.purgeExpired(context.Background()))
+	assert.NotContains(t, fake.files, "c1")
+	assert.NotContains(t, fake.files, "c2")
+	assert.NotContains(t, fake.files, "b")
+	assert.Contains(t, fake.files, "a")
+}
+
+// testFiles are the files under folder "root":
+// a/a1.flv, a/a2.flv, b/c/c1.flv, b/c/c2.flv and r1.flv, sized 1 to 5 from the oldest.
+func testFiles() map[string]*drive.File {
+	files := make(map[string]*drive.File)
+	for _, file := range []*drive.File{
+		{Id: "a", Name: "a", MimeType: folderMimeType, Parents: []string{"root"}},
+		{Id: "b", Name: "b", MimeType: folderMimeType, Parents: []string{"root"}},
+		{Id: "c", Name: "c", MimeType: folderMimeType, Parents: []string{"b"}},
+		{Id: "a1", Name: "a1.flv", Size: 1, ModifiedTime: "2024-05-01T00:00:00Z", Parents: []string{"a"}},
+		{Id: "c1", Name: "c1.flv", Size: 2, ModifiedTime: "2024-05-02T00:00:00Z", Parents: []string{"c"}},
+		{Id: "c2", Name: "c2.flv", Size: 3, ModifiedTime: "2024-05-03T00:00:00Z", Parents: []string{"c"}},
+		{Id: "a2", Name: "a2.flv", Size: 4, ModifiedTime: "2024-05-04T00:00:00Z", Parents: []string{"a"}},
+		{Id: "r1", Name: "r1.flv", Size: 5, ModifiedTime: "2024-05-05T00:00:00Z", Parents: []string{"root"}},
+	} {
+		files[file.Id] = file
+	}
+	return files
+}
+
+func newTestDriveService(t *testing.T, server *httptest.Server) *drive.Service {
+	t.Helper()
+	driveService, err := drive.NewService(context.Background(),
+		option.WithEndpoint(server.URL+"/"), option.WithHTTPClient(server.Client()))
+	require.NoError(t, err)
+	return driveService
+}
@@ -0,0 +1,145 @@
+package gdrive
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+)
+
+const folderMimeType = "application/vnd.google-apps.folder"
+
+// renderPathTemplate renders the remote folder path of a recording from template.
+// Supported placeholders are {roomId}, {shortId}, {streamerName}, {title}, {sessionId}, {relativeDir},
+// and {yyyy}, {MM}, {dd}, {yyyy-MM}, {yyyy-MM-dd} of the file open time.
+// Empty segments are dropped, so that an empty template renders the parent folder itself.
+func renderPathTemplate(template string, eventData *brec.EventDataFileClose) string {
+	openTime, err := time.Parse(brec.TimestampLayout, eventData.FileOpenTime)
+	if err != nil {
+		openTime = time.Now()
+	}
+	sanitize := strings.NewReplacer("/", "_", "\\", "_").Replace
+	rendered := strings.NewReplacer(
+		"{roomId}", fmt.Sprint(eventData.RoomID),
+		"{shortId}", fmt.Sprint(eventData.ShortID),
+		"{streamerName}", sanitize(eventData.StreamerName),
+		"{title}", sanitize(eventData.Title),
+		"{sessionId}", sanitize(eventData.SessionID),
+		"{relativeDir}", path.Dir(eventData.RelativePath),
+		"{yyyy-MM-dd}", openTime.Format("2006-01-02"),
+		"{yyyy-MM}", openTime.Format("2006-01"),
+		"{yyyy}", openTime.Format("2006"),
+		"{MM}", openTime.Format("01"),
+		"{dd}", openTime.Format("02"),
+	).Replace(template)
+
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(rendered, "/") {
+		if segment = strings.TrimSpace(segment); segment != "" && segment != "." && segment != ".." {
+			segments = append(segments, segment)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// folders resolves folder paths under the parent folder to folder IDs, creating missing folders.
+// Resolved IDs are cached until invalidated.
+type folders struct {
+	parentFolderID string
+	sharedDriveID  string
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+func newFolders(parentFolderID, sharedDriveID string) *folders {
+	return &folders{parentFolderID: parentFolderID, sharedDriveID: sharedDriveID, cache: make(map[string]string)}
+}
+
+// resolve returns ID of the folder at folderPath relative to the parent folder, like `mkdir -p`.
+func (f *folders) resolve(ctx context.Context, driveService *drive.Service, folderPath string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	folderID, current := f.parentFolderID, ""
+	for _, name := range strings.Split(folderPath, "/") {
+		if name == "" {
+			continue
+		}
+		current = path.Join(current, name)
+		if cached, ok := f.cache[current]; ok {
+			folderID = cached
+			continue
+		}
+
+		childID, err := f.findOrCreateFolder(ctx, driveService, folderID, name)
+		if err != nil {
+			return "", errors.Wrap(err, "unable to resolve folder "+current)
+		}
+		f.cache[current] = childID
+		folderID = childID
+	}
+	return folderID, nil
+}
+
+// invalidate clears cached folder IDs, e.g. when folders might have been removed.
+func (f *folders) invalidate() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cache = make(map[string]string)
+}
+
+func (f *folders) findOrCreateFolder(
+	ctx context.Context,
+	driveService *drive.Service,
+	parentID, name string,
+) (string, error) {
+	r, err := listFiles(driveService, f.sharedDriveID).
+		Q(fmt.Sprintf(
+			"name = '%s' and '%s' in parents and mimeType = '%s' and trashed = false",
+			escapeQuery(name), parentID, folderMimeType,
+		)).
+		OrderBy("createdTime").
+		PageSize(1).
+		Fields("files(id)").
+		Context(ctx).
+		Do()
+	if err != nil {
+		return "", errors.Wrap(err, "error listing folders")
+	}
+	if len(r.Files) > 0 {
+		return r.Files[0].Id, nil
+	}
+
+	created, err := driveService.Files.
+		Create(&drive.File{Name: name, MimeType: folderMimeType, Parents: []string{parentID}}).
+		SupportsAllDrives(true).
+		Fields("id").
+		Context(ctx).
+		Do()
+	if err != nil {
+		return "", errors.Wrap(err, "error creating folder")
+	}
+	return created.Id, nil
+}
+
+// listFiles creates a request to list files in My Drive, or in the shared drive if sharedDriveID is not empty.
+func listFiles(driveService *drive.Service, sharedDriveID string) *drive.FilesListCall {
+	call := driveService.Files.List().SupportsAllDrives(true)
+	if sharedDriveID != "" {
+		call = call.IncludeItemsFromAllDrives(true).Corpora("drive").DriveId(sharedDriveID)
+	}
+	return call
+}
+
+// escapeQuery escapes value to be quoted in query of files.
+func escapeQuery(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
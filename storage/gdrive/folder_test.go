@@ -0,0 +1,186 @@
+package gdrive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+)
+
+func Test_renderPathTemplate(t *testing.T) {
+	eventData := &brec.EventDataFileClose{
+		RelativePath: "1001-streamer/record.flv",
+		FileOpenTime: "2024-05-14T17:52:54.9092527+08:00",
+		SessionID:    "session",
+		EventDataBase: brec.EventDataBase{
+			RoomID:       1001,
+			StreamerName: "a/b",
+		},
+	}
+	for template, expected := range map[string]string{
+		"": "",
+		"{roomId}-{streamerName}/{yyyy-MM}/{sessionId}": "1001-a_b/2024-05/session",
+		"{relativeDir}/{yyyy}/{MM}/{dd}":                "1001-streamer/2024/05/14",
+		"/archive//{yyyy-MM-dd}/":                       "archive/2024-05-14",
+		"{title}/../{sessionId}":                        "session",
+	} {
+		assert.Equal(t, expected, renderPathTemplate(template, eventData), template)
+	}
+}
+
+func Test_folders_resolve(t *testing.T) {
+	fake := &fakeDrive{files: map[string]*drive.File{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	driveService := newTestDriveService(t, server)
+	f := newFolders("root", "")
+	folderID, err := f.resolve(context.Background(), driveService, "")
+	require.NoError(t, err)
+	assert.Equal(t, "root", folderID)
+
+	folderID, err = f.resolve(context.Background(), driveService, "1001-streamer/2024-05")
+	require.NoError(t, err)
+	require.Contains(t, fake.files, folderID)
+	assert.Equal(t, "2024-05", fake.files[folderID].Name)
+	assert.Len(t, fake.files, 2)
+
+	// cached folders should be reused without listing.
+	fake.requests = 0
+	cached, err := f.resolve(context.Background(), driveService, "1001-streamer/2024-05")
+	require.NoError(t, err)
+	assert.Equal(t, folderID, cached)
+	assert.Zero(t, fake.requests)
+
+	// existing folders should be found after invalidated.
+	f.invalidate()
+	found, err := f.resolve(context.Background(), driveService, "1001-streamer/2024-05")
+	require.NoError(t, err)
+	assert.Equal(t, folderID, found)
+	assert.Len(t, fake.files, 2)
+}
+
+var (
+	queryName   = regexp.MustCompile(`name = '([^']*)'`)
+	queryParent = regexp.MustCompile(`'([^']*)' in parents`)
+	queryMime   = regexp.MustCompile(`mimeType (!?=) '([^']*)'`)
+	queryTrash  = regexp.MustCompile(`trashed = (true|false)`)
+)
+
+// fakeDrive is a minimal google drive server for listing, creating, updating and deleting files.
+// At most pageLimit files are responded per page if it is not zero.
+// Files are listed only from the shared drive driveID if it is not empty.
+// Storage quota is limit, and usage is the total size of files including those in trash.
+type fakeDrive struct {
+	mu        sync.Mutex
+	files     map[string]*drive.File
+	pageLimit int
+	driveID   string
+	limit     int64
+	requests  int
+}
+
+func (f *fakeDrive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+
+	switch r.Method {
+	case http.MethodGet:
+		if r.URL.Path == "/about" {
+			quota := &drive.AboutStorageQuota{Limit: f.limit}
+			for _, file := range f.files {
+				quota.Usage += file.Size
+			}
+			_ = json.NewEncoder(w).Encode(&drive.About{StorageQuota: quota})
+			return
+		}
+		if f.driveID != "" &&
+			(r.URL.Query().Get("corpora") != "drive" || r.URL.Query().Get("driveId") != f.driveID) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(f.list(r.URL.Query()))
+	case http.MethodPost:
+		file := &drive.File{}
+		if err := json.NewDecoder(r.Body).Decode(file); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		file.Id = fmt.Sprintf("folder%d", len(f.files))
+		f.files[file.Id] = file
+		_ = json.NewEncoder(w).Encode(file)
+	case http.MethodPatch:
+		file, ok := f.files[path.Base(r.URL.Path)]
+		update := &drive.File{}
+		if err := json.NewDecoder(r.Body).Decode(update); err != nil || !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		file.Trashed = file.Trashed || update.Trashed
+		for key, value := range update.AppProperties {
+			if file.AppProperties == nil {
+				file.AppProperties = make(map[string]string)
+			}
+			file.AppProperties[key] = value
+		}
+		_ = json.NewEncoder(w).Encode(file)
+	case http.MethodDelete:
+		delete(f.files, path.Base(r.URL.Path))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeDrive) list(query url.Values) *drive.FileList {
+	q := query.Get("q")
+	matches := make([]*drive.File, 0)
+	for _, file := range f.files {
+		if match := queryName.FindStringSubmatch(q); match != nil && file.Name != match[1] {
+			continue
+		}
+		if match := queryParent.FindStringSubmatch(q); match != nil && file.Parents[0] != match[1] {
+			continue
+		}
+		if match := queryMime.FindStringSubmatch(q); match != nil && (file.MimeType == match[2]) != (match[1] == "=") {
+			continue
+		}
+		if match := queryTrash.FindStringSubmatch(q); match != nil && file.Trashed != (match[1] == "true") {
+			continue
+		}
+		matches = append(matches, file)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].ModifiedTime != matches[j].ModifiedTime {
+			return matches[i].ModifiedTime < matches[j].ModifiedTime
+		}
+		return matches[i].Id < matches[j].Id
+	})
+
+	result := &drive.FileList{}
+	offset, _ := strconv.Atoi(query.Get("pageToken"))
+	end := len(matches)
+	if f.pageLimit > 0 && offset+f.pageLimit < end {
+		end = offset + f.pageLimit
+		result.NextPageToken = strconv.Itoa(end)
+	}
+	if offset < end {
+		result.Files = matches[offset:end]
+	}
+	return result
+}
@@ -3,13 +3,15 @@ package gdrive
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"io/fs"
+	"net/http"
 	"os"
 	"path"
 	"time"
 
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/jwt"
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/googleapi"
@@ -17,44 +19,88 @@ import (
 
 	"github.com/ayumi-otosaka-314/brec-pp/brec"
 	"github.com/ayumi-otosaka-314/brec-pp/config"
-	"github.com/ayumi-otosaka-314/brec-pp/notification"
 	"github.com/ayumi-otosaka-314/brec-pp/storage"
 	"github.com/ayumi-otosaka-314/brec-pp/upload"
 )
 
+const (
+	CredentialTypeServiceAccount = "serviceAccount"
+	CredentialTypeOAuthUser      = "oauthUser"
+)
+
+// sessionIDProperty is the app property recording the recording session of uploaded files, for retention policy.
+const sessionIDProperty = "brecSessionId"
+
+// Uploader uploads recordings to google drive, and purges files trashed by cleaner once PurgeTrash is run.
+type Uploader interface {
+	upload.Uploader
+	PurgeTrash(ctx context.Context)
+}
+
 type service struct {
 	logger           *zap.Logger
-	config           *jwt.Config
-	timeout          time.Duration
+	tokenSource      oauth2.TokenSource
 	reservedCapacity uint64
+	dryRun           bool
 	parentFolderID   string
+	sharedDriveID    string
+	quota            uint64
+	trashGracePeriod time.Duration
+	purgeTrashEarly  bool
+	timeout          time.Duration
+	pathTemplate     string
+	retention        *config.Retention
+	folders          *folders
+	chunkSize        uint64
 	localRootPath    string
-	notifier         notification.Service
-	receive          chan *brec.EventDataFileClose
 }
 
-func NewUploadService(
+func NewUploader(
 	logger *zap.Logger,
 	gdriveConfig *config.GoogleDrive,
 	localRootPath string,
-	notifier notification.Service,
-) upload.Service {
-	conf, err := fromServiceAccount(gdriveConfig.CredentialPath)
+) Uploader {
+	tokenSource, err := newTokenSource(gdriveConfig)
 	if err != nil {
 		panic(err)
 	}
-	svc := &service{
+	s := &service{
 		logger:           logger,
-		config:           conf,
-		timeout:          gdriveConfig.Timeout,
+		tokenSource:      tokenSource,
 		reservedCapacity: gdriveConfig.ReservedCapacity,
+		dryRun:           gdriveConfig.DryRun,
 		parentFolderID:   gdriveConfig.ParentFolderID,
+		sharedDriveID:    gdriveConfig.SharedDriveID,
+		quota:            gdriveConfig.Quota,
+		trashGracePeriod: gdriveConfig.TrashGracePeriod,
+		purgeTrashEarly:  gdriveConfig.PurgeTrashEarly,
+		timeout:          gdriveConfig.Timeout,
+		pathTemplate:     gdriveConfig.PathTemplate,
+		retention:        gdriveConfig.Retention,
+		folders:          newFolders(gdriveConfig.ParentFolderID, gdriveConfig.SharedDriveID),
+		chunkSize:        chunkSize(gdriveConfig.ChunkSize),
 		localRootPath:    localRootPath,
-		notifier:         notifier,
-		receive:          make(chan *brec.EventDataFileClose, 16),
 	}
-	go svc.doReceive()
-	return svc
+	return s
+}
+
+// chunkSize rounds configured chunk size down to a multiple of chunkSizeUnit.
+func chunkSize(configured uint64) uint64 {
+	if configured < chunkSizeUnit {
+		return defaultChunkSize
+	}
+	return configured - configured%chunkSizeUnit
+}
+
+func newTokenSource(gdriveConfig *config.GoogleDrive) (oauth2.TokenSource, error) {
+	if gdriveConfig.CredentialType == CredentialTypeOAuthUser {
+		return fromOAuthUser(gdriveConfig.CredentialPath, gdriveConfig.TokenPath)
+	}
+	conf, err := fromServiceAccount(gdriveConfig.CredentialPath)
+	if err != nil {
+		return nil, err
+	}
+	return conf.TokenSource(context.Background()), nil
 }
 
 func fromServiceAccount(credentialPath string) (*jwt.Config, error) {
@@ -80,64 +126,227 @@ func fromServiceAccount(credentialPath string) (*jwt.Config, error) {
 	}, nil
 }
 
-func (s *service) Receive() chan<- *brec.EventDataFileClose {
-	return s.receive
+func (s *service) Upload(ctx context.Context, job *upload.Job, checkpoint upload.Checkpoint) error {
+	return classifyError(s.upload(ctx, job, checkpoint))
 }
 
-func (s *service) doReceive() {
-	for eventData := range s.receive {
-		go func(e *brec.EventDataFileClose) {
-			ctx, _ := context.WithTimeout(context.Background(), s.timeout)
-			if err := s.doUpload(ctx, e); err != nil {
-				s.logger.Error("error uploading file", zap.Error(err),
-					zap.String("streamerName", e.StreamerName), zap.String("filePath", e.RelativePath))
-				s.notifier.Alert(ctx, fmt.Sprintf(
-					"error uploading file [%s] for streamer [%s]",
-					e.RelativePath, e.StreamerName,
-				), err)
+// classifyError treats a missing local recording and client side googleapi errors as permanent.
+// Request timeouts, 429 and the rateLimitExceeded reasons Drive reports under 403 are retried.
+func classifyError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return upload.Permanent(err)
+	}
+
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) ||
+		apiErr.Code < http.StatusBadRequest ||
+		apiErr.Code >= http.StatusInternalServerError ||
+		apiErr.Code == http.StatusRequestTimeout ||
+		apiErr.Code == http.StatusTooManyRequests {
+		return err
+	}
+	for _, item := range apiErr.Errors {
+		if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+			return err
+		}
+	}
+	return upload.Permanent(err)
+}
+
+func (s *service) upload(ctx context.Context, job *upload.Job, checkpoint upload.Checkpoint) error {
+	eventData := job.Event
+	httpClient := oauth2.NewClient(ctx, s.tokenSource)
+	driveService, err := drive.NewService(ctx, option.WithHTTPClient(httpClient))
+	if err != nil {
+		return errors.Wrap(err, "unable to create google drive service")
+	}
+
+	uploadFile, err := os.Open(path.Join(s.localRootPath, eventData.RelativePath))
+	if err != nil {
+		return errors.Wrap(err, "unable to open uploadFile file")
+	}
+	defer uploadFile.Close()
+
+	info, err := uploadFile.Stat()
+	if err != nil {
+		return errors.Wrap(err, "unable to get uploadFile status")
+	}
+
+	fileName := path.Base(eventData.RelativePath)
+	u := &resumableUpload{
+		httpClient: httpClient,
+		file:       uploadFile,
+		size:       uint64(info.Size()),
+		chunkSize:  s.chunkSize,
+		sessionURI: job.ResumeToken,
+		committed:  job.CommittedBytes,
+	}
+	progress := s.logUploadProgress(fileName)
+
+	completed := false
+	if u.sessionURI != "" {
+		s.logger.Info("resuming google drive upload session",
+			zap.String("fileName", fileName), zap.Uint64("committed", u.committed))
+		if completed, err = u.query(ctx); errors.Is(err, errSessionExpired) {
+			s.logger.Warn("google drive upload session expired; restarting upload",
+				zap.String("fileName", fileName))
+			u.sessionURI = ""
+		} else if err != nil {
+			return errors.Wrap(err, "unable to query upload session status")
+		}
+	}
+
+	for failures := 0; !completed; {
+		if u.sessionURI == "" {
+			if err = s.startSession(ctx, driveService, u, eventData); err != nil {
+				return err
+			}
+		} else {
+			committed := u.committed
+			completed, err = u.sendChunk(ctx)
+			if errors.Is(err, errSessionExpired) {
+				u.sessionURI = ""
+				continue
+			}
+			if err != nil {
+				if failures++; failures > maxChunkRetries || ctx.Err() != nil {
+					return errors.Wrap(err, "unable to upload file chunk")
+				}
+				s.logger.Warn("error uploading file chunk; resuming from last committed bytes",
+					zap.Error(err), zap.String("fileName", fileName), zap.Int("failures", failures))
+				if completed, err = u.query(ctx); err != nil && !errors.Is(err, errSessionExpired) {
+					s.logger.Warn("error querying upload session status", zap.Error(err))
+				}
+				continue
+			}
+			if u.committed > committed {
+				failures = 0
 			}
-		}(eventData)
+		}
+		progress(int64(u.committed), int64(u.size))
+		if err = checkpoint(u.sessionURI, u.committed); err != nil {
+			s.logger.Warn("error saving upload checkpoint", zap.Error(err), zap.String("fileName", fileName))
+		}
 	}
-	s.logger.Warn("receive channel closed for google drive uploader")
+
+	checksum, err := u.verify()
+	if err != nil {
+		s.logger.Warn("uploaded file mismatch on google drive; removing uploaded file",
+			zap.Error(err), zap.String("fileName", fileName), zap.String("fileID", u.result.Id))
+		if deleteErr := driveService.Files.Delete(u.result.Id).SupportsAllDrives(true).Context(ctx).Do(); deleteErr != nil {
+			s.logger.Warn("error removing mismatched file from google drive", zap.Error(deleteErr))
+		}
+		// the session is completed, so that the upload has to be restarted on retry.
+		if checkpointErr := checkpoint("", 0); checkpointErr != nil {
+			s.logger.Warn("error saving upload checkpoint", zap.Error(checkpointErr), zap.String("fileName", fileName))
+		}
+		return errors.Wrap(err, "unable to verify uploaded file")
+	}
+	job.Checksum = checksum
+	job.Verified = true
+
+	s.logger.Debug("google drive upload completed", zap.String("fileName", fileName),
+		zap.String("fileID", u.result.Id), zap.String("md5Checksum", checksum))
+	return nil
 }
 
-func (s *service) doUpload(ctx context.Context, eventData *brec.EventDataFileClose) error {
-	start := time.Now()
+// startSession ensures capacity on google drive, and starts a new upload session
+// into the folder rendered from path template.
+func (s *service) startSession(
+	ctx context.Context,
+	driveService *drive.Service,
+	u *resumableUpload,
+	eventData *brec.EventDataFileClose,
+) error {
+	if err := s.ensureCapacity(ctx, driveService, s.reservedCapacity+u.size); err != nil {
+		return err
+	}
 
-	driveService, err := drive.NewService(ctx, option.WithHTTPClient(s.config.Client(ctx)))
+	folderID, err := s.folders.resolve(ctx, driveService, renderPathTemplate(s.pathTemplate, eventData))
+	if err != nil {
+		return err
+	}
+	if err = u.start(ctx, &drive.File{
+		Name:    path.Base(eventData.RelativePath),
+		Parents: []string{folderID},
+		AppProperties: map[string]string{
+			sessionIDProperty:    eventData.SessionID,
+			relativePathProperty: eventData.RelativePath,
+		},
+	}); err != nil {
+		// cached folders might have been removed.
+		s.folders.invalidate()
+		return errors.Wrap(err, "unable to start upload session")
+	}
+	return nil
+}
+
+// EnsureCapacity implements storage.CapacityEnsurer.
+func (s *service) EnsureCapacity(ctx context.Context, targetCapacity uint64) error {
+	driveService, err := drive.NewService(ctx, option.WithHTTPClient(oauth2.NewClient(ctx, s.tokenSource)))
 	if err != nil {
 		return errors.Wrap(err, "unable to create google drive service")
 	}
+	return s.ensureCapacity(ctx, driveService, targetCapacity)
+}
 
-	if err = storage.EnsureCapacity(
-		ctx,
-		s.reservedCapacity+eventData.FileSize,
-		&cleaner{
-			logger:         s.logger,
-			driveService:   driveService,
-			parentFolderID: s.parentFolderID,
-		},
-	); err != nil {
+// ensureCapacity removes recordings by retention policy to ensure capacity,
+// and then purges trash if the capacity is actually needed, unless in dry run.
+func (s *service) ensureCapacity(ctx context.Context, driveService *drive.Service, targetCapacity uint64) error {
+	ctx = storage.DryRun(ctx, s.logger, s.dryRun)
+	c := s.newCleaner(driveService)
+	if err := storage.EnsureCapacity(ctx, targetCapacity, storage.NewRetention(s.logger, c, s.retention)); err != nil {
 		return errors.Wrap(err, "unable to ensure capacity")
 	}
+	if s.trashGracePeriod > 0 && !storage.IsDryRun(ctx) {
+		if err := c.reclaim(ctx, targetCapacity); err != nil {
+			return errors.Wrap(err, "unable to reclaim capacity from trash")
+		}
+	}
+	return nil
+}
 
-	uploadFile, err := os.Open(path.Join(s.localRootPath, eventData.RelativePath))
-	if err != nil {
-		return errors.Wrap(err, "unable to open uploadFile file")
+func (s *service) newCleaner(driveService *drive.Service) *cleaner {
+	return &cleaner{
+		logger:           s.logger,
+		driveService:     driveService,
+		parentFolderID:   s.parentFolderID,
+		sharedDriveID:    s.sharedDriveID,
+		quota:            s.quota,
+		trashGracePeriod: s.trashGracePeriod,
+		purgeEarly:       s.purgeTrashEarly,
+		folders:          s.folders,
+	}
+}
+
+// PurgeTrash purges files trashed longer than trash grace period periodically, until ctx is done.
+// It returns immediately if trash is not used, or in dry run.
+func (s *service) PurgeTrash(ctx context.Context) {
+	if s.trashGracePeriod <= 0 || s.dryRun {
+		return
+	}
+	ticker := time.NewTicker(min(s.trashGracePeriod, time.Hour))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		func() {
+			ctx, cancel := context.WithTimeout(ctx, s.timeout)
+			defer cancel()
+
+			driveService, err := drive.NewService(ctx, option.WithHTTPClient(oauth2.NewClient(ctx, s.tokenSource)))
+			if err != nil {
+				s.logger.Error("unable to create google drive service", zap.Error(err))
+				return
+			}
+			if err = s.newCleaner(driveService).purgeExpired(ctx); err != nil {
+				s.logger.Error("error purging trashed files on google drive", zap.Error(err))
+			}
+		}()
 	}
-	fileName := path.Base(eventData.RelativePath)
-	if _, err = driveService.Files.
-		Create(&drive.File{
-			Name:    fileName,
-			Parents: []string{s.parentFolderID}},
-		).
-		Media(uploadFile).
-		ProgressUpdater(s.logUploadProgress(fileName)).
-		Do(); err != nil {
-		return errors.Wrap(err, "unable to uploadFile")
-	}
-
-	return s.notifier.OnUploadComplete(ctx, time.Now(), eventData, time.Since(start))
 }
 
 func (s *service) logUploadProgress(fileName string) googleapi.ProgressUpdater {
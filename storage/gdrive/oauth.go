@@ -0,0 +1,182 @@
+package gdrive
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+)
+
+// fromOAuthUser creates token source of a personal google account, from the OAuth client credential of installed app,
+// and the token authorized by Authorize at tokenPath. Refreshed tokens are persisted to tokenPath.
+func fromOAuthUser(credentialPath, tokenPath string) (oauth2.TokenSource, error) {
+	conf, err := oauthConfig(credentialPath)
+	if err != nil {
+		return nil, err
+	}
+	token, err := readToken(tokenPath)
+	if err != nil {
+		return nil, err
+	}
+	return &persistentTokenSource{
+		base:  conf.TokenSource(context.Background(), token),
+		path:  tokenPath,
+		token: token,
+	}, nil
+}
+
+func oauthConfig(credentialPath string) (*oauth2.Config, error) {
+	b, err := os.ReadFile(credentialPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading credential file at "+credentialPath)
+	}
+	conf, err := google.ConfigFromJSON(b, drive.DriveScope)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing oauth client credential")
+	}
+	return conf, nil
+}
+
+// Authorize authorizes access of a personal google account via the installed app flow,
+// by printing the consent URL to out and reading the authorization code from in,
+// and writes the token to tokenPath.
+func Authorize(ctx context.Context, credentialPath, tokenPath string, in io.Reader, out io.Writer) error {
+	conf, err := oauthConfig(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	stateBytes := make([]byte, 16)
+	if _, err = rand.Read(stateBytes); err != nil {
+		return errors.Wrap(err, "error generating oauth state")
+	}
+	state := hex.EncodeToString(stateBytes)
+	verifier := oauth2.GenerateVerifier()
+	fmt.Fprintf(out, "Open below URL in browser, and authorize access to google drive:\n\n%s\n\n",
+		conf.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce, oauth2.S256ChallengeOption(verifier)))
+	// no server is listening on the loopback redirect URL of installed app;
+	// the authorization code is copied from the redirected URL in browser instead.
+	fmt.Fprintf(out, "Browser will be redirected to %s which fails to load; "+
+		"paste the URL of that page, or the code in it, here: ", conf.RedirectURL)
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && line == "" {
+		return errors.Wrap(err, "error reading authorization code")
+	}
+	code, err := parseAuthorizationCode(strings.TrimSpace(line), state)
+	if err != nil {
+		return err
+	}
+
+	token, err := conf.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return errors.Wrap(err, "error exchanging authorization code for token")
+	}
+	if token.RefreshToken == "" {
+		return errors.New("refresh token missing in response; please revoke access of the app and authorize again")
+	}
+	if err = writeToken(tokenPath, token); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Token is saved to %s\n", tokenPath)
+	return nil
+}
+
+// parseAuthorizationCode returns the code from either the redirected URL, or the code itself.
+func parseAuthorizationCode(input, state string) (string, error) {
+	if !strings.Contains(input, "?") {
+		if input == "" {
+			return "", errors.New("authorization code is empty")
+		}
+		return input, nil
+	}
+
+	redirected, err := url.Parse(input)
+	if err != nil {
+		return "", errors.Wrap(err, "malformed redirected URL")
+	}
+	query := redirected.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		return "", errors.Errorf("authorization failed: %s", errCode)
+	}
+	if query.Get("state") != state {
+		return "", errors.New("state mismatch in redirected URL")
+	}
+	if query.Get("code") == "" {
+		return "", errors.New("authorization code missing in redirected URL")
+	}
+	return query.Get("code"), nil
+}
+
+func readToken(tokenPath string) (*oauth2.Token, error) {
+	b, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading token file at "+tokenPath)
+	}
+	token := &oauth2.Token{}
+	if err = json.Unmarshal(b, token); err != nil {
+		return nil, errors.Wrap(err, "error unmarshalling token file")
+	}
+	return token, nil
+}
+
+// writeToken replaces the token file at tokenPath, readable by owner only.
+func writeToken(tokenPath string, token *oauth2.Token) error {
+	b, err := json.Marshal(token)
+	if err != nil {
+		return errors.Wrap(err, "error marshalling token")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(tokenPath), filepath.Base(tokenPath)+".*")
+	if err != nil {
+		return errors.Wrap(err, "error creating token file")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "error writing token file")
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrap(err, "error writing token file")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), tokenPath), "error replacing token file")
+}
+
+// persistentTokenSource writes tokens to path whenever they are refreshed,
+// so that the latest refresh token survives restarts.
+type persistentTokenSource struct {
+	base oauth2.TokenSource
+	path string
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+func (s *persistentTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+	// writing is retried on next call if failed, as the refreshed token is still usable.
+	if token.AccessToken != s.token.AccessToken || token.RefreshToken != s.token.RefreshToken {
+		if err = writeToken(s.path, token); err == nil {
+			s.token = token
+		}
+	}
+	return token, nil
+}
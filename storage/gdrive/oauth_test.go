@@ -0,0 +1,88 @@
+package gdrive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestAuthorize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if r.PostForm.Get("code") != "test-code" || r.PostForm.Get("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"access","refresh_token":"refresh","token_type":"Bearer","expires_in":3600}`)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	credentialPath, tokenPath := path.Join(dir, "credential.json"), path.Join(dir, "token.json")
+	require.NoError(t, os.WriteFile(credentialPath, []byte(fmt.Sprintf(
+		`{"installed":{"client_id":"id","client_secret":"secret","auth_uri":"%[1]s/auth","token_uri":"%[1]s/token",`+
+			`"redirect_uris":["http://localhost"]}}`,
+		server.URL,
+	)), 0600))
+
+	out := &strings.Builder{}
+	require.NoError(t, Authorize(context.Background(), credentialPath, tokenPath, strings.NewReader("test-code\n"), out))
+	assert.Contains(t, out.String(), server.URL+"/auth?")
+
+	token, err := readToken(tokenPath)
+	require.NoError(t, err)
+	assert.Equal(t, "access", token.AccessToken)
+	assert.Equal(t, "refresh", token.RefreshToken)
+	info, err := os.Stat(tokenPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func Test_parseAuthorizationCode(t *testing.T) {
+	code, err := parseAuthorizationCode("test-code", "state")
+	require.NoError(t, err)
+	assert.Equal(t, "test-code", code)
+
+	code, err = parseAuthorizationCode("http://localhost/?state=state&code=test-code&scope=drive", "state")
+	require.NoError(t, err)
+	assert.Equal(t, "test-code", code)
+
+	for _, input := range []string{
+		"",
+		"http://localhost/?state=other&code=test-code",
+		"http://localhost/?state=state&error=access_denied",
+		"http://localhost/?state=state",
+	} {
+		_, err = parseAuthorizationCode(input, "state")
+		assert.Error(t, err, input)
+	}
+}
+
+func Test_persistentTokenSource(t *testing.T) {
+	tokenPath := path.Join(t.TempDir(), "token.json")
+	initial := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Minute)}
+	require.NoError(t, writeToken(tokenPath, initial))
+
+	refreshed := &oauth2.Token{AccessToken: "refreshed", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}
+	s := &persistentTokenSource{base: oauth2.StaticTokenSource(refreshed), path: tokenPath, token: initial}
+	token, err := s.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "refreshed", token.AccessToken)
+
+	persisted, err := readToken(tokenPath)
+	require.NoError(t, err)
+	assert.Equal(t, "refreshed", persisted.AccessToken)
+	assert.Equal(t, "refresh", persisted.RefreshToken)
+}
@@ -0,0 +1,82 @@
+package gdrive
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+)
+
+const (
+	// pinnedProperty is the app property of files pinned, which are never removed by cleaner.
+	pinnedProperty = "brecPinned"
+	// relativePathProperty is the app property recording the local relative path of uploaded files, to be pinned by.
+	relativePathProperty = "brecRelativePath"
+)
+
+func isPinned(file *drive.File) bool {
+	return file.AppProperties[pinnedProperty] == "true"
+}
+
+func (s *service) SetPinned(ctx context.Context, pin storage.Pin, pinned bool) error {
+	if err := pin.Validate(); err != nil {
+		return err
+	}
+	driveService, err := drive.NewService(ctx, option.WithHTTPClient(oauth2.NewClient(ctx, s.tokenSource)))
+	if err != nil {
+		return errors.Wrap(err, "unable to create google drive service")
+	}
+	return s.newCleaner(driveService).setPinned(ctx, pin, pinned)
+}
+
+// setPinned updates the pinned property of files under parent folder matching pin,
+// by their local relative paths and sessions recorded on upload.
+func (c *cleaner) setPinned(ctx context.Context, pin storage.Pin, pinned bool) error {
+	parents, err := c.listFolders(ctx)
+	if err != nil {
+		return err
+	}
+
+	matched := make([]*drive.File, 0)
+	for folderID := range parents {
+		if err = listFiles(c.driveService, c.sharedDriveID).
+			Q(fmt.Sprintf("mimeType != '%s' and '%s' in parents and trashed = false", folderMimeType, folderID)).
+			Fields("nextPageToken, files(id, name, appProperties)").
+			Pages(ctx, func(r *drive.FileList) error {
+				for _, file := range r.Files {
+					if pin.Matches(file.AppProperties[relativePathProperty], file.AppProperties[sessionIDProperty]) {
+						matched = append(matched, file)
+					}
+				}
+				return nil
+			}); err != nil {
+			return errors.Wrap(err, "unable to list gdrive files to pin")
+		}
+	}
+	if len(matched) == 0 {
+		c.logger.Warn("no file on google drive matches pin",
+			zap.String("relativePath", pin.RelativePath), zap.String("sessionID", pin.SessionID))
+		return nil
+	}
+
+	for _, file := range matched {
+		c.logger.Info("updating pinned property of file on google drive",
+			zap.String("name", file.Name), zap.String("fileID", file.Id), zap.Bool("pinned", pinned))
+		if _, err = c.driveService.Files.
+			Update(file.Id, &drive.File{AppProperties: map[string]string{pinnedProperty: strconv.FormatBool(pinned)}}).
+			SupportsAllDrives(true).
+			Fields("id").
+			Context(ctx).
+			Do(); err != nil {
+			return errors.Wrap(err, "unable to update pinned property of file on google drive")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package gdrive
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+)
+
+func Test_cleaner_setPinned(t *testing.T) {
+	fake := &fakeDrive{files: testFiles()}
+	fake.files["a1"].AppProperties = map[string]string{relativePathProperty: "a/a1.flv", sessionIDProperty: "s1"}
+	fake.files["c1"].AppProperties = map[string]string{relativePathProperty: "b/c/c1.flv", sessionIDProperty: "s2"}
+	fake.files["c2"].AppProperties = map[string]string{relativePathProperty: "b/c/c2.flv", sessionIDProperty: "s2"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	c := &cleaner{
+		logger:         zaptest.NewLogger(t),
+		driveService:   newTestDriveService(t, server),
+		parentFolderID: "root",
+	}
+	ctx := context.Background()
+	require.NoError(t, c.setPinned(ctx, storage.Pin{SessionID: "s1"}, true))
+	require.NoError(t, c.setPinned(ctx, storage.Pin{RelativePath: "b"}, true))
+	assert.True(t, isPinned(fake.files["a1"]))
+	assert.True(t, isPinned(fake.files["c2"]))
+	assert.Equal(t, "s2", fake.files["c2"].AppProperties[sessionIDProperty], "other properties should be kept")
+
+	require.NoError(t, c.setPinned(ctx, storage.Pin{RelativePath: "b/c/c2.flv"}, false))
+	assert.False(t, isPinned(fake.files["c2"]))
+
+	// pinned files should neither be removed to ensure capacity, nor by retention policy.
+	removables, err := c.GetRemovables(ctx)
+	require.NoError(t, err)
+	sizes := make([]uint64, 0)
+	for remove := range removables {
+		size, err := remove()
+		require.NoError(t, err)
+		sizes = append(sizes, size)
+	}
+	assert.Equal(t, []uint64{3, 4, 5}, sizes)
+	assert.Contains(t, fake.files, "a1")
+	assert.Contains(t, fake.files, "c1")
+
+	entries, err := c.ListEntries(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,236 @@
+package gdrive
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+)
+
+const (
+	resumableUploadURL = "https://www.googleapis.com/upload/drive/v3/files?uploadType=resumable" +
+		"&supportsAllDrives=true&fields=id,name,size,md5Checksum"
+
+	// chunkSizeUnit is the unit of chunk size required by resumable upload protocol.
+	chunkSizeUnit = 256 * 1024
+	// defaultChunkSize is used when chunk size is not configured.
+	defaultChunkSize = 64 * chunkSizeUnit
+
+	// maxChunkRetries is the count of consecutive failed chunk requests tolerated in a single upload.
+	maxChunkRetries = 5
+
+	statusResumeIncomplete = 308
+)
+
+// errSessionExpired is returned when the resumable upload session is no longer valid,
+// and the upload should be restarted from the beginning with a new session.
+var errSessionExpired = errors.New("resumable upload session expired")
+
+// resumableUpload uploads a file to google drive with the resumable upload protocol.
+// https://developers.google.com/drive/api/guides/manage-uploads#resumable
+type resumableUpload struct {
+	httpClient *http.Client
+	file       *os.File
+	size       uint64
+	chunkSize  uint64
+
+	// sessionURI is the URI of the upload session; empty if session is not started yet.
+	sessionURI string
+	// committed is the count of bytes persisted by google drive.
+	committed uint64
+	// result is the file created on google drive when upload is completed.
+	result *drive.File
+
+	// hash is the MD5 hash of the first hashed bytes of file, computed while chunks are sent.
+	hash   hash.Hash
+	hashed uint64
+}
+
+// start initiates a new upload session for the file with given metadata.
+func (u *resumableUpload) start(ctx context.Context, metadata *drive.File) error {
+	raw, err := jsoniter.Marshal(metadata)
+	if err != nil {
+		return errors.Wrap(err, "error marshalling file metadata")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, resumableUploadURL, bytes.NewReader(raw))
+	if err != nil {
+		return errors.Wrap(err, "error creating resumable upload session request")
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatUint(u.size, 10))
+
+	resp, err := u.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error requesting resumable upload session")
+	}
+	defer resp.Body.Close()
+	if err = googleapi.CheckResponse(resp); err != nil {
+		return errors.Wrap(err, "unexpected response creating resumable upload session")
+	}
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return errors.New("resumable upload session URI missing in response")
+	}
+	u.sessionURI = location
+	u.committed = 0
+	return nil
+}
+
+// query updates the committed byte count of current session.
+// It returns true if the upload has been completed.
+func (u *resumableUpload) query(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.sessionURI, http.NoBody)
+	if err != nil {
+		return false, errors.Wrap(err, "error creating upload status request")
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", u.size))
+	return u.do(req)
+}
+
+// sendChunk uploads the next chunk starting from committed bytes.
+// It returns true if the upload has been completed.
+func (u *resumableUpload) sendChunk(ctx context.Context) (bool, error) {
+	length := u.chunkSize
+	if remaining := u.size - u.committed; remaining < length {
+		length = remaining
+	}
+
+	// the chunk is hashed as it is sent; the hash is rolled back if the chunk is not fully committed.
+	start := u.committed
+	if err := u.hashUpTo(start); err != nil {
+		return false, err
+	}
+	snapshot, err := u.hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return false, errors.Wrap(err, "error saving checksum state")
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPut,
+		u.sessionURI,
+		io.TeeReader(io.NewSectionReader(u.file, int64(start), int64(length)), u.hash),
+	)
+	if err != nil {
+		return false, errors.Wrap(err, "error creating upload chunk request")
+	}
+	req.ContentLength = int64(length)
+	if length > 0 {
+		req.Header.Set("Content-Range",
+			fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, u.size))
+	} else {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", u.size))
+	}
+
+	completed, err := u.do(req)
+	if u.committed == start+length {
+		u.hashed = u.committed
+	} else if restoreErr := u.hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(snapshot); restoreErr != nil {
+		u.hash = nil
+	}
+	return completed, err
+}
+
+// hashUpTo updates hash with bytes of file until offset.
+// Bytes not hashed while sent, e.g. committed before restart, are read from file again.
+func (u *resumableUpload) hashUpTo(offset uint64) error {
+	if u.hash == nil || u.hashed > offset {
+		u.hash = md5.New()
+		u.hashed = 0
+	}
+	if u.hashed == offset {
+		return nil
+	}
+	if _, err := io.Copy(u.hash, io.NewSectionReader(u.file, int64(u.hashed), int64(offset-u.hashed))); err != nil {
+		return errors.Wrap(err, "error reading file for checksum")
+	}
+	u.hashed = offset
+	return nil
+}
+
+// checksum returns the hex encoded MD5 checksum of the whole file.
+func (u *resumableUpload) checksum() (string, error) {
+	if err := u.hashUpTo(u.size); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(u.hash.Sum(nil)), nil
+}
+
+// verify compares size and MD5 checksum of the uploaded file with the local file.
+// It returns the verified checksum.
+func (u *resumableUpload) verify() (string, error) {
+	checksum, err := u.checksum()
+	if err != nil {
+		return "", err
+	}
+	if err = storage.VerifySize(int64(u.size), u.result.Size); err != nil {
+		return "", err
+	}
+	return checksum, storage.VerifyChecksum(checksum, u.result.Md5Checksum)
+}
+
+func (u *resumableUpload) do(req *http.Request) (bool, error) {
+	resp, err := u.httpClient.Do(req)
+	if err != nil {
+		return false, errors.Wrap(err, "error sending resumable upload request")
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated:
+		u.committed = u.size
+		u.result = &drive.File{}
+		if err = jsoniter.NewDecoder(resp.Body).Decode(u.result); err != nil {
+			return true, errors.Wrap(err, "unable to decode uploaded file metadata")
+		}
+		return true, nil
+	case resp.StatusCode == statusResumeIncomplete:
+		committed, err := parseRangeHeader(resp.Header.Get("Range"))
+		if err != nil {
+			return false, err
+		}
+		u.committed = committed
+		return false, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return false, errSessionExpired
+	default:
+		if err = googleapi.CheckResponse(resp); err != nil {
+			return false, errors.Wrap(err, "unexpected response of resumable upload")
+		}
+		return false, errors.Errorf("unexpected response status [%s] of resumable upload", resp.Status)
+	}
+}
+
+// parseRangeHeader returns the count of committed bytes from Range header in form of "bytes=0-42".
+// Empty header means no bytes have been committed.
+func parseRangeHeader(header string) (uint64, error) {
+	if header == "" {
+		return 0, nil
+	}
+	_, last, found := strings.Cut(strings.TrimPrefix(header, "bytes="), "-")
+	if !found {
+		return 0, errors.Errorf("malformed range header [%s]", header)
+	}
+	lastByte, err := strconv.ParseUint(last, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "malformed range header [%s]", header)
+	}
+	return lastByte + 1, nil
+}
@@ -0,0 +1,148 @@
+package gdrive
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+)
+
+// trashedAtProperty is the app property recording when a file is trashed by cleaner,
+// as trashedTime of files is only populated in shared drives.
+// Only files trashed by cleaner are purged.
+const trashedAtProperty = "brecTrashedAt"
+
+// trashedFile is a file trashed by cleaner.
+type trashedFile struct {
+	*drive.File
+	folderID  string
+	trashedAt time.Time
+}
+
+// trash moves the file to trash, recording the time trashed.
+func (c *cleaner) trash(ctx context.Context, file *drive.File) error {
+	c.logger.Debug("trashing file on google drive",
+		zap.String("name", file.Name), zap.String("fileID", file.Id), zap.Int64("size", file.Size))
+	_, err := c.driveService.Files.
+		Update(file.Id, &drive.File{
+			Trashed:       true,
+			AppProperties: map[string]string{trashedAtProperty: time.Now().UTC().Format(time.RFC3339)},
+		}).
+		SupportsAllDrives(true).
+		Fields("id").
+		Context(ctx).
+		Do()
+	return errors.Wrap(err, "unable to trash file on google drive")
+}
+
+// listTrashed returns files trashed by cleaner under parent folder, the earliest trashed first.
+func (c *cleaner) listTrashed(ctx context.Context) ([]*trashedFile, error) {
+	parents, err := c.listFolders(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*trashedFile, 0)
+	for folderID := range parents {
+		if err = listFiles(c.driveService, c.sharedDriveID).
+			Q(fmt.Sprintf("mimeType != '%s' and '%s' in parents and trashed = true", folderMimeType, folderID)).
+			Fields("nextPageToken, files(id, name, size, appProperties)").
+			Pages(ctx, func(r *drive.FileList) error {
+				for _, file := range r.Files {
+					trashedAt, err := time.Parse(time.RFC3339, file.AppProperties[trashedAtProperty])
+					if err != nil {
+						continue
+					}
+					result = append(result, &trashedFile{File: file, folderID: folderID, trashedAt: trashedAt})
+				}
+				return nil
+			}); err != nil {
+			return nil, errors.Wrap(err, "unable to list trashed files on google drive")
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].trashedAt.Before(result[j].trashedAt)
+	})
+	return result, nil
+}
+
+// purgeExpired deletes files trashed by cleaner for longer than the grace period,
+// and then folders emptied by purge.
+func (c *cleaner) purgeExpired(ctx context.Context) error {
+	trashed, err := c.listTrashed(ctx)
+	if err != nil {
+		return err
+	}
+	expired := make([]*trashedFile, 0)
+	for _, file := range trashed {
+		if time.Since(file.trashedAt) >= c.trashGracePeriod {
+			expired = append(expired, file)
+		}
+	}
+	return c.purge(ctx, expired)
+}
+
+// reclaim purges expired files in trash, the earliest trashed first, until the capacity actually available reaches target.
+// It is called before upload, as trashed files are considered available by GetAvailableCapacity.
+// Files within grace period are only purged if purging early is enabled; otherwise ErrCapacityNotEnsured is returned,
+// so that a bad capacity calculation never loses recordings before the grace period.
+func (c *cleaner) reclaim(ctx context.Context, target uint64) error {
+	available, err := c.getFreeCapacity(ctx)
+	if err != nil || available >= target {
+		return err
+	}
+	trashed, err := c.listTrashed(ctx)
+	if err != nil {
+		return err
+	}
+
+	toPurge := make([]*trashedFile, 0)
+	for _, file := range trashed {
+		if available >= target {
+			break
+		}
+		if time.Since(file.trashedAt) < c.trashGracePeriod {
+			if !c.purgeEarly {
+				break
+			}
+			c.logger.Warn("purging file on google drive before trash grace period to ensure capacity",
+				zap.String("name", file.Name), zap.Time("trashedAt", file.trashedAt))
+		}
+		toPurge = append(toPurge, file)
+		available += uint64(file.Size)
+	}
+	if err = c.purge(ctx, toPurge); err != nil {
+		return err
+	}
+	if available < target {
+		return errors.Wrapf(storage.ErrCapacityNotEnsured,
+			"files in trash within grace period are needed for capacity; available [%d], target [%d]", available, target)
+	}
+	return nil
+}
+
+func (c *cleaner) purge(ctx context.Context, files []*trashedFile) error {
+	if len(files) == 0 {
+		return nil
+	}
+	parents, err := c.listFolders(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		c.logger.Debug("purging trashed file from google drive",
+			zap.String("name", file.Name), zap.String("fileID", file.Id), zap.Int64("size", file.Size))
+		if err = c.driveService.Files.Delete(file.Id).SupportsAllDrives(true).Context(ctx).Do(); err != nil {
+			return errors.Wrap(err, "unable to purge trashed file on google drive")
+		}
+		c.removeEmptyFolders(ctx, file.folderID, parents)
+	}
+	return nil
+}
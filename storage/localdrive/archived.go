@@ -0,0 +1,58 @@
+package localdrive
+
+import (
+	"context"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/upload"
+)
+
+const (
+	AfterUploadKeep   = "keep"
+	AfterUploadDelete = "delete"
+	AfterUploadMove   = "move"
+)
+
+// NewOnArchived creates the handler of archived recordings under rootPath by action.
+// Recordings are either deleted, or moved under uploadedPath keeping their relative paths,
+// only if they are verified by checksum on all required destinations; others are kept.
+// It returns nil if recordings should be kept.
+func NewOnArchived(logger *zap.Logger, rootPath, action, uploadedPath string) upload.OnArchived {
+	if action != AfterUploadDelete && action != AfterUploadMove {
+		return nil
+	}
+	return func(_ context.Context, eventData *brec.EventDataFileClose, verified bool) error {
+		filePath := path.Join(rootPath, eventData.RelativePath)
+		if !verified {
+			logger.Warn("keeping archived file not verified by checksum", zap.String("path", filePath))
+			return nil
+		}
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return errors.Wrap(err, "unable to get archived file status")
+		}
+		// the file might have been replaced since uploaded.
+		if uint64(info.Size()) != eventData.FileSize {
+			return errors.Errorf("archived file size changed from [%d] to [%d]; keeping file",
+				eventData.FileSize, info.Size())
+		}
+
+		if action == AfterUploadDelete {
+			logger.Info("deleting archived file from local drive", zap.String("path", filePath))
+			return errors.Wrap(os.Remove(filePath), "unable to delete archived file")
+		}
+
+		targetPath := path.Join(uploadedPath, eventData.RelativePath)
+		logger.Info("moving archived file on local drive",
+			zap.String("path", filePath), zap.String("targetPath", targetPath))
+		if err = os.MkdirAll(path.Dir(targetPath), 0755); err != nil {
+			return errors.Wrap(err, "unable to create uploaded directory")
+		}
+		return errors.Wrap(os.Rename(filePath, targetPath), "unable to move archived file")
+	}
+}
@@ -0,0 +1,60 @@
+package localdrive
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+)
+
+func TestNewOnArchived(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, NewOnArchived(zaptest.NewLogger(t), t.TempDir(), "", ""))
+	assert.Nil(t, NewOnArchived(zaptest.NewLogger(t), t.TempDir(), AfterUploadKeep, ""))
+
+	t.Run("delete", func(t *testing.T) {
+		rootPath := createTempFiles(t)
+		onArchived := NewOnArchived(zaptest.NewLogger(t), rootPath, AfterUploadDelete, "")
+
+		require.NoError(t, onArchived(context.Background(),
+			&brec.EventDataFileClose{RelativePath: "nonEmptyDir/test3", FileSize: 15}, true))
+		assert.NoFileExists(t, path.Join(rootPath, "nonEmptyDir", "test3"))
+	})
+
+	t.Run("move", func(t *testing.T) {
+		rootPath, uploadedPath := createTempFiles(t), t.TempDir()
+		onArchived := NewOnArchived(zaptest.NewLogger(t), rootPath, AfterUploadMove, uploadedPath)
+
+		require.NoError(t, onArchived(context.Background(),
+			&brec.EventDataFileClose{RelativePath: "nonEmptyDir/test3", FileSize: 15}, true))
+		assert.NoFileExists(t, path.Join(rootPath, "nonEmptyDir", "test3"))
+		content, err := os.ReadFile(path.Join(uploadedPath, "nonEmptyDir", "test3"))
+		require.NoError(t, err)
+		assert.Equal(t, "test3test3test3", string(content))
+	})
+
+	t.Run("size changed", func(t *testing.T) {
+		rootPath := createTempFiles(t)
+		onArchived := NewOnArchived(zaptest.NewLogger(t), rootPath, AfterUploadDelete, "")
+
+		assert.Error(t, onArchived(context.Background(),
+			&brec.EventDataFileClose{RelativePath: "test1", FileSize: 3}, true))
+		assert.FileExists(t, path.Join(rootPath, "test1"))
+	})
+
+	t.Run("not verified", func(t *testing.T) {
+		rootPath := createTempFiles(t)
+		onArchived := NewOnArchived(zaptest.NewLogger(t), rootPath, AfterUploadDelete, "")
+
+		require.NoError(t, onArchived(context.Background(),
+			&brec.EventDataFileClose{RelativePath: "nonEmptyDir/test3", FileSize: 15}, false))
+		assert.FileExists(t, path.Join(rootPath, "nonEmptyDir", "test3"))
+	})
+}
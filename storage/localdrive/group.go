@@ -0,0 +1,128 @@
+package localdrive
+
+import (
+	"path"
+	"strings"
+
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+)
+
+const (
+	// GroupingFile removes recording files one by one.
+	GroupingFile = "file"
+	// GroupingSession removes all files of a recording session together, along with their sidecar files.
+	GroupingSession = "session"
+	// GroupingDirectory removes each directory under the folder of streamer as a whole,
+	// for recorder layouts keeping every session in its own directory.
+	GroupingDirectory = "directory"
+)
+
+// localEntry is an entry listed from local drive, along with the states deciding its removal.
+type localEntry struct {
+	*storage.Entry
+	// directory is true if the entry is an empty directory.
+	directory bool
+	// recording is true if the entry is a recording received, whose session is known.
+	recording bool
+	archived  bool
+	// protected is true if the entry is pinned, being recorded or pending upload.
+	protected bool
+
+	// members are the entries grouped, which are removed in order.
+	members []*localEntry
+}
+
+// group merges entries of the same group into single entries by grouping mode, keeping the order of first members.
+// A group is protected if any of its members is protected, so that sessions are never removed partially;
+// it is archived if any of its members is archived, as members pending upload protect the group already.
+func group(entries []*localEntry, grouping string) []*localEntry {
+	if grouping != GroupingSession && grouping != GroupingDirectory {
+		return entries
+	}
+
+	sessions := recordingSessions(entries)
+	result := make([]*localEntry, 0, len(entries))
+	groups := make(map[string]*localEntry)
+	for _, entry := range entries {
+		key := groupKey(entry, grouping, sessions)
+		grouped, ok := groups[key]
+		if !ok {
+			grouped = &localEntry{Entry: &storage.Entry{
+				Path:    entry.Path,
+				Session: sessions[stem(entry.Path)],
+			}}
+			groups[key] = grouped
+			result = append(result, grouped)
+		}
+		grouped.add(entry)
+	}
+	for _, grouped := range result {
+		grouped.Remove = grouped.removeMembers
+	}
+	return result
+}
+
+func groupKey(entry *localEntry, grouping string, sessions map[string]string) string {
+	if entry.directory {
+		return "path:" + entry.Path
+	}
+	if grouping == GroupingDirectory {
+		// paths in directories of sessions are "streamer/session/file".
+		if segments := strings.SplitN(entry.Path, "/", 3); len(segments) == 3 {
+			return "directory:" + segments[0] + "/" + segments[1]
+		}
+	}
+	if session, ok := sessions[stem(entry.Path)]; ok {
+		return "session:" + session
+	}
+	return "stem:" + stem(entry.Path)
+}
+
+// recordingSessions returns sessions of recordings received by their stems,
+// as sidecar files, e.g. danmaku XML, share the name of their recording before the first dot.
+func recordingSessions(entries []*localEntry) map[string]string {
+	sessions := make(map[string]string)
+	for _, entry := range entries {
+		if entry.recording && entry.Session != "" {
+			sessions[stem(entry.Path)] = entry.Session
+		}
+	}
+	return sessions
+}
+
+// add merges member into the group; the group is as new as its latest member.
+func (g *localEntry) add(member *localEntry) {
+	g.Members = append(g.Members, member.Path)
+	g.Size += member.Size
+	if member.LastModified.After(g.LastModified) {
+		g.LastModified = member.LastModified
+	}
+	if g.Session == "" {
+		g.Session = member.Session
+	}
+	g.archived = g.archived || member.archived
+	g.protected = g.protected || member.protected
+	g.members = append(g.members, member)
+}
+
+// removeMembers removes all members of the group, stopping at the first error.
+func (g *localEntry) removeMembers() (uint64, error) {
+	var cleared uint64
+	for _, member := range g.members {
+		size, err := member.Remove()
+		cleared += size
+		if err != nil {
+			return cleared, err
+		}
+	}
+	return cleared, nil
+}
+
+// stem returns relativePath up to the first dot of its base name.
+func stem(relativePath string) string {
+	dir, base := path.Split(relativePath)
+	if i := strings.Index(base, "."); i > 0 {
+		base = base[:i]
+	}
+	return dir + base
+}
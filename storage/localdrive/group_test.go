@@ -0,0 +1,68 @@
+package localdrive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+)
+
+func Test_group(t *testing.T) {
+	t.Parallel()
+
+	removed := make([]string, 0)
+	newEntry := func(relativePath, session string, size uint64, protected bool) *localEntry {
+		return &localEntry{
+			Entry: &storage.Entry{
+				Path:         relativePath,
+				Size:         size,
+				LastModified: time.Unix(int64(size), 0),
+				Session:      session,
+				Remove: func() (uint64, error) {
+					removed = append(removed, relativePath)
+					return size, nil
+				},
+			},
+			recording: session != "",
+			protected: protected,
+		}
+	}
+	entries := []*localEntry{
+		newEntry("room/1-a.flv", "s1", 1, false),
+		newEntry("room/1-a.xml", "", 2, false),
+		newEntry("room/1-b.flv", "s1", 3, false),
+		newEntry("room/1-b.cover.jpg", "", 4, false),
+		newEntry("room/2-a.flv", "s2", 5, true),
+		newEntry("room/2-a.xml", "", 6, false),
+		newEntry("room/old.flv", "", 7, false),
+		newEntry("room/old.xml", "", 8, false),
+		newEntry("room/live/1.flv", "", 9, false),
+		newEntry("room/live/1.xml", "", 10, false),
+		newEntry("room/live/2.flv", "", 11, false),
+	}
+
+	assert.Equal(t, entries, group(entries, GroupingFile), "files should not be grouped by default")
+
+	grouped := group(entries, GroupingSession)
+	require.Len(t, grouped, 5)
+	assert.Equal(t, []string{"room/1-a.flv", "room/1-a.xml", "room/1-b.flv", "room/1-b.cover.jpg"}, grouped[0].Members)
+	assert.Equal(t, "s1", grouped[0].Session)
+	assert.Equal(t, uint64(10), grouped[0].Size)
+	assert.Equal(t, time.Unix(4, 0), grouped[0].LastModified, "session should be as new as its latest file")
+	assert.True(t, grouped[1].protected, "session should be protected with any of its files")
+	assert.Equal(t, []string{"room/old.flv", "room/old.xml"}, grouped[2].Members, "sidecar files should be grouped by name")
+	assert.Equal(t, []string{"room/live/1.flv", "room/live/1.xml"}, grouped[3].Members)
+
+	size, err := grouped[0].Remove()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), size)
+	assert.Equal(t, grouped[0].Members, removed)
+
+	grouped = group(entries, GroupingDirectory)
+	require.Len(t, grouped, 4)
+	assert.Equal(t, []string{"room/live/1.flv", "room/live/1.xml", "room/live/2.flv"}, grouped[3].Members,
+		"directory should be grouped as a whole")
+}
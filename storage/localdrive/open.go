@@ -0,0 +1,87 @@
+package localdrive
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ayumi-otosaka-314/brec-pp/state"
+)
+
+// OpenFiles tracks recordings being written under rootPath, from FileOpening until FileClosed,
+// so that they are never removed while recording.
+type OpenFiles struct {
+	mu    sync.Mutex
+	paths map[string]struct{}
+
+	// store persists recordings being written if not nil, as FileOpening is not sent again after restart.
+	store  *state.Store
+	bucket string
+}
+
+// NewOpenFiles creates OpenFiles tracked in memory only.
+func NewOpenFiles() *OpenFiles {
+	return &OpenFiles{paths: make(map[string]struct{})}
+}
+
+// LoadOpenFiles creates OpenFiles persisted in store by rootPath, loading recordings being written before restart.
+func LoadOpenFiles(store *state.Store, rootPath string) (*OpenFiles, error) {
+	o := &OpenFiles{
+		paths:  make(map[string]struct{}),
+		store:  store,
+		bucket: "openFiles/" + rootPath,
+	}
+	if err := store.ForEach(o.bucket, func(key string, _ []byte) error {
+		o.paths[key] = struct{}{}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "error loading open recordings")
+	}
+	return o, nil
+}
+
+// Open records recording at relativePath as being written.
+// The recording is tracked in memory even if it could not be persisted.
+func (o *OpenFiles) Open(relativePath string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	key := filepath.ToSlash(relativePath)
+	o.paths[key] = struct{}{}
+	if o.store == nil {
+		return nil
+	}
+	return errors.Wrap(o.store.Put(o.bucket, key, time.Now()), "error persisting open recording")
+}
+
+// Close records recording at relativePath as no longer being written.
+func (o *OpenFiles) Close(relativePath string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	key := filepath.ToSlash(relativePath)
+	delete(o.paths, key)
+	if o.store == nil {
+		return nil
+	}
+	return errors.Wrap(o.store.Delete(o.bucket, key), "error persisting closed recording")
+}
+
+// IsOpen reports if recording at relativePath is being written.
+func (o *OpenFiles) IsOpen(relativePath string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, ok := o.paths[filepath.ToSlash(relativePath)]
+	return ok
+}
+
+// Paths returns relative paths of recordings being written.
+func (o *OpenFiles) Paths() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	paths := make([]string, 0, len(o.paths))
+	for p := range o.paths {
+		paths = append(paths, p)
+	}
+	return paths
+}
@@ -0,0 +1,38 @@
+package localdrive
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ayumi-otosaka-314/brec-pp/state"
+)
+
+func TestLoadOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	store, err := state.Open(dir)
+	require.NoError(t, err)
+
+	openFiles, err := LoadOpenFiles(store, "/var")
+	require.NoError(t, err)
+	require.NoError(t, openFiles.Open("room/recording.flv"))
+	require.NoError(t, openFiles.Open("room/closed.flv"))
+	require.NoError(t, openFiles.Close("room/closed.flv"))
+	require.NoError(t, store.Close())
+
+	// reopen to ensure recordings being written are protected after restart.
+	store, err = state.Open(dir)
+	require.NoError(t, err)
+	defer store.Close()
+
+	openFiles, err = LoadOpenFiles(store, "/var")
+	require.NoError(t, err)
+	assert.True(t, openFiles.IsOpen("room/recording.flv"))
+	assert.False(t, openFiles.IsOpen("room/closed.flv"))
+	assert.Equal(t, []string{"room/recording.flv"}, openFiles.Paths())
+
+	openFiles, err = LoadOpenFiles(store, "/other")
+	require.NoError(t, err)
+	assert.False(t, openFiles.IsOpen("room/recording.flv"), "recordings should be persisted by root path")
+}
@@ -0,0 +1,60 @@
+package localdrive
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/ayumi-otosaka-314/brec-pp/state"
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+)
+
+// Pins persists local recordings pinned in the state store, keyed by relative path or session ID.
+// Pins are named by the root path of recordings, so that they are shared by all entries cleaning the same recordings.
+// It implements storage.Pinner.
+type Pins struct {
+	store  *state.Store
+	bucket string
+}
+
+func NewPins(store *state.Store, rootPath string) *Pins {
+	return &Pins{
+		store:  store,
+		bucket: "localPins/" + rootPath,
+	}
+}
+
+func (p *Pins) SetPinned(_ context.Context, pin storage.Pin, pinned bool) error {
+	if err := pin.Validate(); err != nil {
+		return err
+	}
+	key := "path:" + pin.RelativePath
+	if pin.SessionID != "" {
+		key = "session:" + pin.SessionID
+	}
+	if !pinned {
+		return p.store.Delete(p.bucket, key)
+	}
+	return p.store.Put(p.bucket, key, time.Now())
+}
+
+// IsPinned reports if recording at relativePath is pinned, by its path or any directory containing it,
+// or by its session if sessionID is not empty.
+// Errors loading pins are treated as pinned, so that recordings are never removed by mistake.
+func (p *Pins) IsPinned(relativePath, sessionID string) bool {
+	keys := make([]string, 0)
+	if sessionID != "" {
+		keys = append(keys, "session:"+sessionID)
+	}
+	for current := relativePath; current != "." && current != "/"; current = path.Dir(current) {
+		keys = append(keys, "path:"+current)
+	}
+
+	var pinnedAt time.Time
+	for _, key := range keys {
+		if found, err := p.store.Get(p.bucket, key, &pinnedAt); found || err != nil {
+			return true
+		}
+	}
+	return false
+}
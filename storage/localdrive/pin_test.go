@@ -0,0 +1,46 @@
+package localdrive
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ayumi-otosaka-314/brec-pp/state"
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+)
+
+func TestPins(t *testing.T) {
+	store, err := state.Open(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	pins := NewPins(store, "test")
+	ctx := context.Background()
+	require.Error(t, pins.SetPinned(ctx, storage.Pin{}, true), "either path or session should be set")
+	require.NoError(t, pins.SetPinned(ctx, storage.Pin{RelativePath: "/nonEmptyDir/"}, true))
+	require.NoError(t, pins.SetPinned(ctx, storage.Pin{SessionID: "session"}, true))
+
+	assert.True(t, pins.IsPinned("nonEmptyDir/test3", ""), "files under pinned directory should be pinned")
+	assert.True(t, pins.IsPinned("test1", "session"))
+	assert.False(t, pins.IsPinned("test2", ""))
+
+	s := &service{
+		logger:   zaptest.NewLogger(t),
+		rootPath: createTempFiles(t),
+		archives: fakeArchives{"test1": {SessionID: "session"}},
+		pins:     pins,
+	}
+	entries, err := s.ListEntries(ctx)
+	require.NoError(t, err)
+	paths := make([]string, 0)
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	assert.ElementsMatch(t, []string{"test2", "emptyDir"}, paths, "pinned recordings should not be removable")
+
+	require.NoError(t, pins.SetPinned(ctx, storage.Pin{SessionID: "session"}, false))
+	assert.False(t, pins.IsPinned("test1", "session"))
+}
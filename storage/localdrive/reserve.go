@@ -0,0 +1,116 @@
+package localdrive
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/config"
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+)
+
+const (
+	// defaultReservedCapacity is reserved if no reserve is configured,
+	// and estimated in adaptive mode until recordings of the room are observed.
+	defaultReservedCapacity = uint64(5 * storage.GigaBytes)
+	// recentSegments is the count of recent recordings of each room the adaptive estimate is based on.
+	recentSegments = 8
+	// adaptiveHeadroom is the ratio to the estimated size, for bitrate fluctuates.
+	adaptiveHeadroom = 1.2
+)
+
+// Reserve decides the capacity to be reserved on local drive before a new recording file is opened,
+// which is the largest among the absolute reserve, the percentage of disk size,
+// and the estimated size of the next recording in adaptive mode.
+type Reserve struct {
+	rootPath        string
+	absolute        uint64
+	percentage      float64
+	adaptive        bool
+	segmentDuration time.Duration
+	files           uint64
+
+	mu     sync.Mutex
+	recent map[uint64][]segment
+}
+
+// segment is the size and duration in seconds of a recording closed.
+type segment struct {
+	size     uint64
+	duration float64
+}
+
+func NewReserve(conf config.LocalStorage, rootPath string) *Reserve {
+	r := &Reserve{
+		rootPath:        rootPath,
+		absolute:        conf.ReservedCapacity,
+		percentage:      conf.ReservedPercentage,
+		adaptive:        conf.Adaptive,
+		segmentDuration: conf.SegmentDuration,
+		files:           conf.ReservedFiles,
+		recent:          make(map[uint64][]segment),
+	}
+	if r.absolute == 0 && r.percentage == 0 && !r.adaptive {
+		r.absolute = defaultReservedCapacity
+	}
+	return r
+}
+
+// Observe records size and duration of the recording closed, for the adaptive estimate of its room.
+func (r *Reserve) Observe(eventData *brec.EventDataFileClose) {
+	if !r.adaptive || eventData.Duration <= 0 || eventData.FileSize == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	recent := append(r.recent[eventData.RoomID], segment{size: eventData.FileSize, duration: eventData.Duration})
+	if len(recent) > recentSegments {
+		recent = recent[len(recent)-recentSegments:]
+	}
+	r.recent[eventData.RoomID] = recent
+}
+
+// Capacity returns the capacity to be reserved before recording of the room.
+func (r *Reserve) Capacity(roomID uint64) uint64 {
+	capacity := r.absolute
+	if r.percentage > 0 {
+		var statfs unix.Statfs_t
+		if err := unix.Statfs(r.rootPath, &statfs); err == nil {
+			capacity = max(capacity, uint64(float64(statfs.Blocks*uint64(statfs.Bsize))*r.percentage/100))
+		}
+	}
+	if r.adaptive {
+		capacity = max(capacity, r.estimate(roomID))
+	}
+	return capacity
+}
+
+// Target returns the capacity in bytes and free inodes to be reserved before recording of the room.
+func (r *Reserve) Target(roomID uint64) storage.Target {
+	return storage.Target{Bytes: r.Capacity(roomID), Files: r.files}
+}
+
+// estimate returns the expected size of the next recording of the room, from the bitrate of recent recordings,
+// and either the segment duration configured or the longest duration among recent recordings.
+func (r *Reserve) estimate(roomID uint64) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	recent := r.recent[roomID]
+	if len(recent) == 0 {
+		return defaultReservedCapacity
+	}
+
+	var totalSize, totalDuration, longest float64
+	for _, s := range recent {
+		totalSize += float64(s.size)
+		totalDuration += s.duration
+		longest = max(longest, s.duration)
+	}
+	duration := longest
+	if r.segmentDuration > 0 {
+		duration = r.segmentDuration.Seconds()
+	}
+	return uint64(totalSize / totalDuration * duration * adaptiveHeadroom)
+}
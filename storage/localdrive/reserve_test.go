@@ -0,0 +1,44 @@
+package localdrive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/config"
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+)
+
+func TestReserve_Capacity(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, defaultReservedCapacity, NewReserve(config.LocalStorage{}, t.TempDir()).Capacity(1),
+		"default capacity should be reserved if not configured")
+
+	r := NewReserve(config.LocalStorage{ReservedPercentage: 100}, t.TempDir())
+	assert.Greater(t, r.Capacity(1), uint64(0), "disk size should be reserved")
+
+	r = NewReserve(config.LocalStorage{ReservedCapacity: 100, Adaptive: true}, t.TempDir())
+	assert.Equal(t, defaultReservedCapacity, r.Capacity(1), "default capacity should be estimated before observed")
+
+	closed := func(roomID, size uint64, duration float64) *brec.EventDataFileClose {
+		return &brec.EventDataFileClose{FileSize: size, Duration: duration, EventDataBase: brec.EventDataBase{RoomID: roomID}}
+	}
+	r.Observe(closed(1, 1000, 10))
+	r.Observe(closed(1, 3000, 10))
+	r.Observe(closed(2, 1, 1))
+	assert.Equal(t, uint64(2400), r.Capacity(1), "bitrate of 200 B/s for the longest duration of 10s, with headroom")
+	assert.Equal(t, uint64(100), r.Capacity(2), "absolute capacity should be reserved if larger")
+
+	r.segmentDuration = time.Minute
+	assert.Equal(t, uint64(14400), r.Capacity(1), "bitrate for the segment duration configured")
+}
+
+func TestReserve_Target(t *testing.T) {
+	t.Parallel()
+
+	r := NewReserve(config.LocalStorage{ReservedCapacity: 100, ReservedFiles: 10}, t.TempDir())
+	assert.Equal(t, storage.Target{Bytes: 100, Files: 10}, r.Target(1))
+}
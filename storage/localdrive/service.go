@@ -2,8 +2,11 @@ package localdrive
 
 import (
 	"context"
+	"io/fs"
+	"math"
 	"os"
 	"path"
+	"path/filepath"
 	"sort"
 	"time"
 
@@ -11,27 +14,162 @@ import (
 	"go.uber.org/zap"
 	"golang.org/x/sys/unix"
 
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/config"
 	"github.com/ayumi-otosaka-314/brec-pp/storage"
 )
 
 type service struct {
 	rootPath string
 	logger   *zap.Logger
+
+	// archives looks up recordings received under rootPath; it could be nil.
+	// Archived recordings are removed before others.
+	archives Archives
+	// pins are recordings never removed; it could be nil.
+	pins *Pins
+	// openFiles are recordings being written, which are never removed; it could be nil.
+	openFiles *OpenFiles
+	// grouping is the unit of removal, either GroupingFile, GroupingSession or GroupingDirectory.
+	grouping string
+	// quota is the total size of files allowed under rootPath; it is unlimited if zero.
+	quota uint64
+}
+
+// Archives looks up recordings received under rootPath by relative path; it is implemented by upload.Archives.
+type Archives interface {
+	// IsArchived reports if the recording has been uploaded.
+	IsArchived(relativePath string) bool
+	// IsPending reports if the recording has been received but not uploaded yet.
+	IsPending(relativePath string) bool
+	// Recording returns the recording received, or nil if it is unknown.
+	Recording(relativePath string) *brec.EventDataFileClose
+}
+
+// joinedArchives looks up recordings in archives of all entries sharing rootPath.
+type joinedArchives []Archives
+
+// JoinArchives returns Archives looking up recordings in all archives,
+// e.g. those of streamers recording under the same rootPath.
+func JoinArchives(archives ...Archives) Archives {
+	if len(archives) == 1 {
+		return archives[0]
+	}
+	return joinedArchives(archives)
+}
+
+func (j joinedArchives) IsArchived(relativePath string) bool {
+	for _, archives := range j {
+		if archives.IsArchived(relativePath) {
+			return true
+		}
+	}
+	return false
+}
+
+func (j joinedArchives) IsPending(relativePath string) bool {
+	for _, archives := range j {
+		if archives.IsPending(relativePath) {
+			return true
+		}
+	}
+	return false
+}
+
+func (j joinedArchives) Recording(relativePath string) *brec.EventDataFileClose {
+	for _, archives := range j {
+		if recording := archives.Recording(relativePath); recording != nil {
+			return recording
+		}
+	}
+	return nil
 }
 
-func New(logger *zap.Logger, rootPath string) storage.Cleaner {
-	return &service{rootPath: rootPath, logger: logger}
+// New creates local drive cleaner, which implements storage.Lister and storage.FileCounter.
+// Files are removed one by one unless grouping of conf is GroupingSession or GroupingDirectory.
+func New(
+	logger *zap.Logger,
+	rootPath string,
+	conf config.LocalStorage,
+	archives Archives,
+	pins *Pins,
+	openFiles *OpenFiles,
+) storage.Lister {
+	return &service{
+		rootPath:  rootPath,
+		logger:    logger,
+		archives:  archives,
+		pins:      pins,
+		openFiles: openFiles,
+		grouping:  conf.Grouping,
+		quota:     conf.Quota,
+	}
 }
 
+// GetAvailableCapacity returns available bytes of the file system, limited by quota under rootPath if configured.
 func (s *service) GetAvailableCapacity() (uint64, error) {
 	var statfs unix.Statfs_t
 	if err := unix.Statfs(s.rootPath, &statfs); err != nil {
 		return 0, err
 	}
-	return statfs.Bavail * uint64(statfs.Bsize), nil
+	available := statfs.Bavail * uint64(statfs.Bsize)
+	if s.quota == 0 {
+		return available, nil
+	}
+
+	usage, err := s.usage()
+	if err != nil {
+		return 0, err
+	}
+	if usage >= s.quota {
+		return 0, nil
+	}
+	return min(available, s.quota-usage), nil
+}
+
+// GetAvailableFiles returns free inodes of the file system, which are unlimited if not reported by the file system.
+func (s *service) GetAvailableFiles() (uint64, error) {
+	var statfs unix.Statfs_t
+	if err := unix.Statfs(s.rootPath, &statfs); err != nil {
+		return 0, err
+	}
+	if statfs.Files == 0 {
+		return math.MaxUint64, nil
+	}
+	return statfs.Ffree, nil
+}
+
+// usage returns total size of files under rootPath.
+func (s *service) usage() (uint64, error) {
+	var usage uint64
+	err := filepath.WalkDir(s.rootPath, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		usage += uint64(info.Size())
+		return nil
+	})
+	return usage, errors.Wrap(err, "unable to measure usage under root path")
 }
 
 func (s *service) GetRemovables(ctx context.Context) (<-chan storage.DoRemove, error) {
+	entries, err := s.ListEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return storage.Removables(ctx, entries), nil
+}
+
+// ListEntries returns files and empty directories under rootPath, grouped by grouping mode,
+// except those pinned, being recorded or pending upload, archived first and then the oldest first.
+func (s *service) ListEntries(ctx context.Context) ([]*storage.Entry, error) {
 	var traverseDepth = 2 // traverse 2 levels by default.
 	val := ctx.Value(keyTraverseDepth)
 	if ctxDepth, ok := val.(int); ok && ctxDepth > 0 {
@@ -49,33 +187,65 @@ func (s *service) GetRemovables(ctx context.Context) (<-chan storage.DoRemove, e
 		entries = entries[1:]
 	}
 
-	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].lastModified.Before(entries[j].lastModified)
-	})
-
-	result := make(chan storage.DoRemove)
-	go func() {
-		defer close(result)
-
-		for _, entry := range entries {
-			entry := entry
-			doRemove := func() (uint64, error) {
-				removePath := path.Join(entry.parentPath, entry.name)
-				s.logger.Debug("deleting file from local drive",
-					zap.String("path", removePath), zap.Uint64("size", entry.size))
-				return entry.size, os.Remove(removePath)
-			}
-			select {
-			case result <- doRemove:
-				continue
-			case <-ctx.Done():
-				s.logger.Debug("local drive get removable finished", zap.Error(ctx.Err()))
-				return
+	listed := make([]*localEntry, 0, len(entries))
+	for _, entry := range entries {
+		removePath := path.Join(entry.parentPath, entry.name)
+		relativePath, err := filepath.Rel(s.rootPath, removePath)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to get relative path")
+		}
+		e := &localEntry{Entry: &storage.Entry{
+			Path:         filepath.ToSlash(relativePath),
+			Size:         entry.size,
+			LastModified: entry.lastModified,
+			Remove: func() (uint64, error) {
+				return storage.Remove(ctx, removePath, entry.size, func() error {
+					s.logger.Debug("deleting file from local drive",
+						zap.String("path", removePath), zap.Uint64("size", entry.size))
+					return os.Remove(removePath)
+				})
+			},
+		}, directory: entry.name == ""}
+		if s.archives != nil && entry.name != "" {
+			e.archived = s.archives.IsArchived(relativePath)
+			e.protected = s.archives.IsPending(relativePath)
+			if recording := s.archives.Recording(relativePath); recording != nil {
+				e.Session = recording.SessionID
+				e.recording = true
 			}
 		}
-	}()
+		listed = append(listed, e)
+	}
 
-	return result, nil
+	// sidecar files, e.g. danmaku XML, belong to the session of their recording.
+	sessions := recordingSessions(listed)
+	for _, e := range listed {
+		if e.Session == "" && !e.directory {
+			e.Session = sessions[stem(e.Path)]
+		}
+		e.protected = e.protected ||
+			(s.openFiles != nil && s.openFiles.IsOpen(e.Path)) ||
+			(s.pins != nil && s.pins.IsPinned(e.Path, e.Session))
+	}
+
+	result := make([]*localEntry, 0, len(listed))
+	for _, e := range group(listed, s.grouping) {
+		if !e.protected {
+			result = append(result, e)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].archived != result[j].archived {
+			return result[i].archived
+		}
+		return result[i].LastModified.Before(result[j].LastModified)
+	})
+
+	removables := make([]*storage.Entry, 0, len(result))
+	for _, e := range result {
+		removables = append(removables, e.Entry)
+	}
+	return removables, nil
 }
 
 type fileEntry struct {
@@ -10,6 +10,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
 
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
 	"github.com/ayumi-otosaka-314/brec-pp/storage"
 )
 
@@ -61,6 +62,29 @@ func Test_service_GetRemovables_emptyDir(t *testing.T) {
 	assert.Equal(t, 0, count) // no entries should be returned
 }
 
+func Test_service_GetRemovables_archivedFirst(t *testing.T) {
+	t.Parallel()
+
+	testPath := createTempFiles(t)
+	s := &service{
+		logger:   zaptest.NewLogger(t),
+		rootPath: testPath,
+		archives: fakeArchives{"nonEmptyDir/test3": {SessionID: "session"}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	removables, err := s.GetRemovables(ctx)
+	require.NoError(t, err)
+
+	remove := <-removables
+	size, err := remove()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(15), size, "archived file should be removed first")
+	assert.NoFileExists(t, path.Join(testPath, "nonEmptyDir", "test3"))
+}
+
 func TestEnsureLocalCapacity(t *testing.T) {
 	testPath := createTempFiles(t)
 	s := &service{
@@ -77,6 +101,108 @@ func TestEnsureLocalCapacity(t *testing.T) {
 	))
 }
 
+func Test_service_ListEntries(t *testing.T) {
+	t.Parallel()
+
+	s := &service{
+		logger:   zaptest.NewLogger(t),
+		rootPath: createTempFiles(t),
+		archives: fakeArchives{"nonEmptyDir/test3": {SessionID: "session"}},
+	}
+
+	entries, err := s.ListEntries(context.Background())
+	require.NoError(t, err)
+	paths := make([]string, 0)
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	assert.ElementsMatch(t, []string{"nonEmptyDir/test3", "test1", "test2", "emptyDir"}, paths)
+	assert.Equal(t, "nonEmptyDir/test3", entries[0].Path, "archived file should be listed first")
+	assert.Equal(t, "session", entries[0].Session)
+}
+
+func Test_service_ListEntries_sidecarSession(t *testing.T) {
+	t.Parallel()
+
+	rootPath := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(rootPath, "room"), 0755))
+	for _, name := range []string{"room/a.flv", "room/a.xml", "room/b.xml"} {
+		require.NoError(t, os.WriteFile(path.Join(rootPath, name), []byte("x"), 0644))
+	}
+	s := &service{
+		logger:   zaptest.NewLogger(t),
+		rootPath: rootPath,
+		archives: fakeArchives{"room/a.flv": {SessionID: "session"}},
+	}
+
+	entries, err := s.ListEntries(context.Background())
+	require.NoError(t, err)
+	sessions := make(map[string]string)
+	for _, entry := range entries {
+		sessions[entry.Path] = entry.Session
+	}
+	assert.Equal(t, map[string]string{"room/a.flv": "session", "room/a.xml": "session", "room/b.xml": ""}, sessions,
+		"sidecar files should belong to the session of their recording")
+}
+
+func Test_service_ListEntries_protected(t *testing.T) {
+	t.Parallel()
+
+	openFiles := NewOpenFiles()
+	openFiles.Open("test1")
+	s := &service{
+		logger:    zaptest.NewLogger(t),
+		rootPath:  createTempFiles(t),
+		archives:  pendingArchives{"nonEmptyDir/test3": true},
+		openFiles: openFiles,
+	}
+
+	entries, err := s.ListEntries(context.Background())
+	require.NoError(t, err)
+	paths := make([]string, 0)
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	assert.ElementsMatch(t, []string{"test2", "emptyDir"}, paths,
+		"recordings being written or pending upload should not be removable")
+
+	openFiles.Close("test1")
+	entries, err = s.ListEntries(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, entries, 3, "recording should be removable once closed")
+}
+
+// pendingArchives are recordings received but not uploaded yet by relative path.
+type pendingArchives map[string]bool
+
+func (a pendingArchives) IsArchived(string) bool {
+	return false
+}
+
+func (a pendingArchives) IsPending(relativePath string) bool {
+	return a[relativePath]
+}
+
+func (a pendingArchives) Recording(string) *brec.EventDataFileClose {
+	return nil
+}
+
+// fakeArchives are archived recordings by relative path.
+type fakeArchives map[string]*brec.EventDataFileClose
+
+func (a fakeArchives) IsArchived(relativePath string) bool {
+	_, ok := a[relativePath]
+	return ok
+}
+
+func (a fakeArchives) IsPending(string) bool {
+	return false
+}
+
+func (a fakeArchives) Recording(relativePath string) *brec.EventDataFileClose {
+	return a[relativePath]
+}
+
 func createTempFiles(t *testing.T) string {
 	t.Helper()
 	testPath := t.TempDir()
@@ -101,3 +227,26 @@ func createTempFiles(t *testing.T) string {
 
 	return testPath
 }
+
+func Test_service_GetAvailableCapacity_quota(t *testing.T) {
+	t.Parallel()
+
+	// files created are 5 + 10 + 15 bytes.
+	s := &service{
+		logger:   zaptest.NewLogger(t),
+		rootPath: createTempFiles(t),
+		quota:    40,
+	}
+	available, err := s.GetAvailableCapacity()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), available, "usage under root path should be deducted from quota")
+
+	s.quota = 20
+	available, err = s.GetAvailableCapacity()
+	require.NoError(t, err)
+	assert.Zero(t, available, "nothing should be available over quota")
+
+	files, err := s.GetAvailableFiles()
+	require.NoError(t, err)
+	assert.Positive(t, files)
+}
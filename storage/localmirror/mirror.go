@@ -0,0 +1,218 @@
+package localmirror
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/ayumi-otosaka-314/brec-pp/config"
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+	"github.com/ayumi-otosaka-314/brec-pp/storage/localdrive"
+	"github.com/ayumi-otosaka-314/brec-pp/upload"
+)
+
+const (
+	modeMove = "move"
+
+	// partialSuffix is appended to mirrored file name until it is copied and verified.
+	partialSuffix = ".part"
+)
+
+// service implements upload.Uploader by copying or moving recordings to another local path.
+// The mirror path is cleaned with the same strategy as local drive to ensure capacity.
+type service struct {
+	logger           *zap.Logger
+	mirror           storage.Cleaner
+	mirrorPath       string
+	move             bool
+	verifyChecksum   bool
+	reservedCapacity uint64
+	dryRun           bool
+	localRootPath    string
+
+	// copying are partial files being copied by workers, which are never removed to ensure capacity.
+	copying *localdrive.OpenFiles
+}
+
+func NewUploader(
+	logger *zap.Logger,
+	mirrorConfig *config.LocalMirror,
+	localRootPath string,
+) upload.Uploader {
+	copying := localdrive.NewOpenFiles()
+	return &service{
+		logger:           logger,
+		mirror:           localdrive.New(logger, mirrorConfig.Path, config.LocalStorage{}, nil, nil, copying),
+		mirrorPath:       mirrorConfig.Path,
+		copying:          copying,
+		move:             mirrorConfig.Mode == modeMove,
+		verifyChecksum:   mirrorConfig.VerifyChecksum,
+		reservedCapacity: mirrorConfig.ReservedCapacity,
+		dryRun:           mirrorConfig.DryRun,
+		localRootPath:    localRootPath,
+	}
+}
+
+func (s *service) Upload(ctx context.Context, job *upload.Job, _ upload.Checkpoint) error {
+	err := s.upload(ctx, job)
+	if errors.Is(err, fs.ErrNotExist) {
+		return upload.Permanent(err)
+	}
+	return err
+}
+
+// EnsureCapacity implements storage.CapacityEnsurer.
+func (s *service) EnsureCapacity(ctx context.Context, targetCapacity uint64) error {
+	return storage.EnsureCapacity(storage.DryRun(ctx, s.logger, s.dryRun), targetCapacity, s.mirror)
+}
+
+func (s *service) upload(ctx context.Context, job *upload.Job) error {
+	eventData := job.Event
+	sourcePath := path.Join(s.localRootPath, eventData.RelativePath)
+	targetPath := path.Join(s.mirrorPath, eventData.RelativePath)
+	if err := os.MkdirAll(path.Dir(targetPath), 0755); err != nil {
+		return errors.Wrap(err, "unable to create mirror directory")
+	}
+
+	// renaming is preferred when moving within the same file system.
+	if s.move {
+		err := os.Rename(sourcePath, targetPath)
+		if err == nil {
+			s.logger.Debug("local mirror move completed", zap.String("path", targetPath))
+			return nil
+		}
+		if !errors.Is(err, syscall.EXDEV) {
+			return errors.Wrap(err, "unable to move file to mirror")
+		}
+	}
+
+	partialPath := eventData.RelativePath + partialSuffix
+	s.copying.Open(partialPath)
+	defer s.copying.Close(partialPath)
+	if err := s.EnsureCapacity(ctx, s.reservedCapacity+eventData.FileSize); err != nil {
+		return errors.Wrap(err, "unable to ensure capacity")
+	}
+	if err := s.copy(ctx, job, sourcePath, targetPath); err != nil {
+		return err
+	}
+
+	if s.move {
+		if err := os.Remove(sourcePath); err != nil {
+			return errors.Wrap(err, "unable to remove source file after mirrored")
+		}
+	}
+	s.logger.Debug("local mirror copy completed", zap.String("path", targetPath), zap.Bool("move", s.move))
+	return nil
+}
+
+// Verify compares size of the mirrored file with the source file.
+// Checksum is compared on copy if enabled, and the source file no longer exists in move mode.
+func (s *service) Verify(_ context.Context, job *upload.Job) error {
+	info, err := os.Stat(path.Join(s.mirrorPath, job.Event.RelativePath))
+	if err != nil {
+		return errors.Wrap(err, "unable to get mirror file status")
+	}
+	return storage.VerifySize(int64(job.Event.FileSize), info.Size())
+}
+
+// copy copies source to a partial file, and renames it to target once verified.
+// The checksum of job is set once verified by checksum.
+func (s *service) copy(ctx context.Context, job *upload.Job, sourcePath, targetPath string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return errors.Wrap(err, "unable to open source file")
+	}
+	defer source.Close()
+
+	info, err := source.Stat()
+	if err != nil {
+		return errors.Wrap(err, "unable to get source file status")
+	}
+
+	partialPath := targetPath + partialSuffix
+	target, err := os.Create(partialPath)
+	if err != nil {
+		return errors.Wrap(err, "unable to create mirror file")
+	}
+	defer os.Remove(partialPath)
+	defer target.Close()
+
+	var reader io.Reader = &contextReader{ctx: ctx, reader: source}
+	var sourceHash hash.Hash
+	if s.verifyChecksum {
+		sourceHash = sha256.New()
+		reader = io.TeeReader(reader, sourceHash)
+	}
+	if _, err = io.Copy(target, reader); err != nil {
+		return errors.Wrap(err, "unable to copy file to mirror")
+	}
+	if err = target.Sync(); err != nil {
+		return errors.Wrap(err, "unable to sync mirror file")
+	}
+	if err = target.Close(); err != nil {
+		return errors.Wrap(err, "unable to close mirror file")
+	}
+
+	if err = s.verify(ctx, partialPath, info.Size(), sourceHash); err != nil {
+		return err
+	}
+	if err = os.Rename(partialPath, targetPath); err != nil {
+		return errors.Wrap(err, "unable to rename mirror file")
+	}
+	if sourceHash != nil {
+		job.Checksum = hex.EncodeToString(sourceHash.Sum(nil))
+	}
+	return nil
+}
+
+// verify compares size of mirrored file, and its checksum if sourceHash is provided.
+func (s *service) verify(ctx context.Context, mirroredPath string, size int64, sourceHash hash.Hash) error {
+	mirrored, err := os.Open(mirroredPath)
+	if err != nil {
+		return errors.Wrap(err, "unable to open mirror file")
+	}
+	defer mirrored.Close()
+
+	info, err := mirrored.Stat()
+	if err != nil {
+		return errors.Wrap(err, "unable to get mirror file status")
+	}
+	if info.Size() != size {
+		return errors.Errorf("mirror file size mismatch: expected [%d], got [%d]", size, info.Size())
+	}
+	if sourceHash == nil {
+		return nil
+	}
+
+	mirroredHash := sha256.New()
+	if _, err = io.Copy(mirroredHash, &contextReader{ctx: ctx, reader: mirrored}); err != nil {
+		return errors.Wrap(err, "unable to read mirror file")
+	}
+	if !bytes.Equal(sourceHash.Sum(nil), mirroredHash.Sum(nil)) {
+		return errors.New("mirror file checksum mismatch")
+	}
+	return nil
+}
+
+// contextReader stops reading once ctx is done, so that copying large files respects upload timeout.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
@@ -0,0 +1,102 @@
+package localmirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/config"
+	"github.com/ayumi-otosaka-314/brec-pp/upload"
+)
+
+func Test_service_Upload(t *testing.T) {
+	t.Parallel()
+
+	for _, mode := range []string{"", "copy", "move"} {
+		localRootPath, mirrorPath := t.TempDir(), t.TempDir()
+		svc := NewUploader(zaptest.NewLogger(t), &config.LocalMirror{
+			Timeout:        time.Minute,
+			Path:           mirrorPath,
+			Mode:           mode,
+			VerifyChecksum: true,
+		}, localRootPath)
+
+		content := strings.Repeat("0123456789", 100)
+		relativePath := "1001-测试/录制-1001.flv"
+		require.NoError(t, os.MkdirAll(path.Join(localRootPath, path.Dir(relativePath)), 0755))
+		require.NoError(t, os.WriteFile(path.Join(localRootPath, relativePath), []byte(content), 0644))
+
+		job := &upload.Job{Event: &brec.EventDataFileClose{RelativePath: relativePath, FileSize: uint64(len(content))}}
+		require.NoError(t, svc.Upload(context.Background(), job, nil), mode)
+
+		mirrored, err := os.ReadFile(path.Join(mirrorPath, relativePath))
+		require.NoError(t, err, mode)
+		assert.Equal(t, content, string(mirrored), mode)
+		assert.NoFileExists(t, path.Join(mirrorPath, relativePath+partialSuffix), mode)
+		if mode == "move" {
+			assert.NoFileExists(t, path.Join(localRootPath, relativePath), mode)
+		} else {
+			assert.FileExists(t, path.Join(localRootPath, relativePath), mode)
+			assert.Len(t, job.Checksum, 64, "copy should be verified by SHA-256 checksum")
+		}
+	}
+}
+
+func Test_service_EnsureCapacity_copying(t *testing.T) {
+	t.Parallel()
+
+	mirrorPath := t.TempDir()
+	svc := NewUploader(zaptest.NewLogger(t), &config.LocalMirror{Timeout: time.Minute, Path: mirrorPath}, t.TempDir()).(*service)
+	for _, name := range []string{"room/old.flv", "room/copying.flv" + partialSuffix} {
+		require.NoError(t, os.MkdirAll(path.Join(mirrorPath, "room"), 0755))
+		require.NoError(t, os.WriteFile(path.Join(mirrorPath, name), []byte("xxx"), 0644))
+	}
+	svc.copying.Open("room/copying.flv" + partialSuffix)
+
+	assert.Error(t, svc.EnsureCapacity(context.Background(), math.MaxUint64))
+	assert.NoFileExists(t, path.Join(mirrorPath, "room/old.flv"))
+	assert.FileExists(t, path.Join(mirrorPath, "room/copying.flv"+partialSuffix), "file being copied should not be removed")
+}
+
+func Test_service_Upload_missingFile(t *testing.T) {
+	t.Parallel()
+
+	for _, mode := range []string{"copy", "move"} {
+		svc := NewUploader(zaptest.NewLogger(t), &config.LocalMirror{
+			Timeout: time.Minute,
+			Path:    t.TempDir(),
+			Mode:    mode,
+		}, t.TempDir())
+
+		err := svc.Upload(context.Background(), &upload.Job{Event: &brec.EventDataFileClose{RelativePath: "missing.flv"}}, nil)
+		assert.True(t, upload.IsPermanent(err), mode)
+	}
+}
+
+func Test_service_verify(t *testing.T) {
+	t.Parallel()
+
+	s := &service{verifyChecksum: true}
+	filePath := path.Join(t.TempDir(), "test.flv")
+	require.NoError(t, os.WriteFile(filePath, []byte("content"), 0644))
+
+	assert.Error(t, s.verify(context.Background(), filePath, 6, nil))
+	assert.NoError(t, s.verify(context.Background(), filePath, 7, nil))
+
+	sourceHash := sha256.New()
+	sourceHash.Write([]byte("contents"))
+	assert.Error(t, s.verify(context.Background(), filePath, 7, sourceHash))
+	sourceHash.Reset()
+	sourceHash.Write([]byte("content"))
+	assert.NoError(t, s.verify(context.Background(), filePath, 7, sourceHash))
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Pin identifies recordings to be pinned, either by path of a recording or a directory containing recordings,
+// relative to the root of storage, or by recording session ID.
+type Pin struct {
+	RelativePath string `json:"relativePath,omitempty"`
+	SessionID    string `json:"sessionId,omitempty"`
+}
+
+// Validate ensures exactly one of relative path and session ID is set, and cleans the relative path.
+func (p *Pin) Validate() error {
+	if (p.RelativePath == "") == (p.SessionID == "") {
+		return errors.New("exactly one of relative path and session ID should be set to pin")
+	}
+	if p.RelativePath != "" {
+		p.RelativePath = path.Clean(strings.TrimPrefix(p.RelativePath, "/"))
+		if p.RelativePath == "." || p.RelativePath == ".." || strings.HasPrefix(p.RelativePath, "../") {
+			return errors.Errorf("invalid relative path [%s] to pin", p.RelativePath)
+		}
+	}
+	return nil
+}
+
+// Matches reports if recording at relativePath of sessionID is pinned by p.
+func (p *Pin) Matches(relativePath, sessionID string) bool {
+	if p.SessionID != "" {
+		return p.SessionID == sessionID
+	}
+	return relativePath == p.RelativePath || strings.HasPrefix(relativePath, p.RelativePath+"/")
+}
+
+// Pinner pins recordings, so that they are never removed by cleaner, neither to ensure capacity nor by retention policy.
+type Pinner interface {
+	// SetPinned pins recordings identified by pin, or unpins them if pinned is false.
+	SetPinned(ctx context.Context, pin Pin, pinned bool) error
+}
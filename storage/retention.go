@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/ayumi-otosaka-314/brec-pp/config"
+)
+
+// Entry is a removable recording listed by Lister.
+type Entry struct {
+	// Path is the slash separated path of the entry relative to the root of storage.
+	Path         string
+	Size         uint64
+	LastModified time.Time
+	// Session is the recording session of the entry, if known;
+	// entries of unknown sessions are not counted as sessions kept by retention policy.
+	Session string
+	// Members are the paths of all files removed together with the entry if it groups several files, e.g. a session.
+	Members []string
+	// Remove actually removes the entry.
+	Remove DoRemove
+}
+
+// streamer returns the first segment of Path, which is the folder of streamer in layouts of recorder.
+func (e *Entry) streamer() string {
+	if i := strings.Index(e.Path, "/"); i >= 0 {
+		return e.Path[:i]
+	}
+	return ""
+}
+
+// Lister is a Cleaner able to list all its removables at once, so that retention policy could be applied.
+type Lister interface {
+	Cleaner
+	// ListEntries returns the removable entries in the order they should be removed to ensure capacity.
+	ListEntries(ctx context.Context) ([]*Entry, error)
+}
+
+// Enforcer is a Cleaner removing entries by its own rules, regardless of capacity.
+// EnsureCapacity enforces the rules before ensuring capacity.
+type Enforcer interface {
+	Enforce(ctx context.Context) error
+}
+
+// Removables sends removal of entries in order, until all are sent or ctx is done.
+func Removables(ctx context.Context, entries []*Entry) <-chan DoRemove {
+	result := make(chan DoRemove)
+	go func() {
+		defer close(result)
+
+		for _, entry := range entries {
+			select {
+			case result <- entry.Remove:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return result
+}
+
+// retention wraps a Lister with retention policy.
+// Entries are protected from removal if they are matched by protected patterns, newer than minimum age,
+// or in the latest sessions kept of their streamer; other entries are removed once older than maximum age,
+// or from the oldest once their streamer exceeds quota, and are removed in the order listed to ensure capacity.
+type retention struct {
+	Lister
+	logger *zap.Logger
+
+	maxAge        time.Duration
+	minAge        time.Duration
+	keepSessions  int
+	streamerQuota uint64
+	protected     []string
+}
+
+// NewRetention applies retention policy to removables of lister; lister is returned as is if conf is nil.
+func NewRetention(logger *zap.Logger, lister Lister, conf *config.Retention) Cleaner {
+	if conf == nil {
+		return lister
+	}
+	return &retention{
+		Lister:        lister,
+		logger:        logger,
+		maxAge:        conf.MaxAge,
+		minAge:        conf.MinAge,
+		keepSessions:  int(conf.KeepSessions),
+		streamerQuota: conf.StreamerQuota,
+		protected:     conf.Protected,
+	}
+}
+
+// GetAvailableFiles implements FileCounter if the lister does; files are unlimited otherwise.
+func (r *retention) GetAvailableFiles() (uint64, error) {
+	if counter, ok := r.Lister.(FileCounter); ok {
+		return counter.GetAvailableFiles()
+	}
+	return math.MaxUint64, nil
+}
+
+func (r *retention) GetRemovables(ctx context.Context) (<-chan DoRemove, error) {
+	entries, err := r.ListEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Removables(ctx, r.removables(entries)), nil
+}
+
+func (r *retention) Enforce(ctx context.Context) error {
+	if r.maxAge == 0 && r.streamerQuota == 0 {
+		return nil
+	}
+	entries, err := r.ListEntries(ctx)
+	if err != nil {
+		return err
+	}
+	removables := r.removables(entries)
+
+	usage := make(map[string]uint64)
+	for _, entry := range entries {
+		usage[entry.streamer()] += entry.Size
+	}
+	sort.SliceStable(removables, func(i, j int) bool {
+		return removables[i].LastModified.Before(removables[j].LastModified)
+	})
+	for _, entry := range removables {
+		streamer := entry.streamer()
+		expired := r.maxAge > 0 && time.Since(entry.LastModified) > r.maxAge
+		overQuota := r.streamerQuota > 0 && usage[streamer] > r.streamerQuota
+		if !expired && !overQuota {
+			continue
+		}
+
+		r.logger.Info("removing recording by retention policy",
+			zap.String("path", entry.Path), zap.Bool("expired", expired), zap.Bool("overQuota", overQuota))
+		if _, err = entry.Remove(); err != nil {
+			return errors.Wrap(err, "error removing recording by retention policy")
+		}
+		usage[streamer] -= min(entry.Size, usage[streamer])
+	}
+	return nil
+}
+
+// removables returns entries not protected, keeping their order.
+func (r *retention) removables(entries []*Entry) []*Entry {
+	kept := r.keptSessions(entries)
+	result := make([]*Entry, 0, len(entries))
+	for _, entry := range entries {
+		if r.isProtected(entry) || (entry.Session != "" && kept[entry.streamer()+"\x00"+entry.Session]) {
+			continue
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func (r *retention) isProtected(entry *Entry) bool {
+	if r.minAge > 0 && time.Since(entry.LastModified) < r.minAge {
+		return true
+	}
+	for _, member := range append([]string{entry.Path}, entry.Members...) {
+		if r.isProtectedPath(member) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *retention) isProtectedPath(entryPath string) bool {
+	for _, pattern := range r.protected {
+		if matched, _ := path.Match(pattern, entryPath); matched {
+			return true
+		}
+		// patterns matching a directory protect everything under it.
+		for dir := path.Dir(entryPath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if matched, _ := path.Match(pattern, dir); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keptSessions returns the latest sessions of each streamer to be kept, keyed by streamer and session.
+// Sessions are ordered by their latest modified entries; entries of unknown sessions are left out.
+func (r *retention) keptSessions(entries []*Entry) map[string]bool {
+	kept := make(map[string]bool)
+	if r.keepSessions <= 0 {
+		return kept
+	}
+
+	latest := make(map[string]time.Time)
+	for _, entry := range entries {
+		if entry.Session == "" {
+			continue
+		}
+		key := entry.streamer() + "\x00" + entry.Session
+		if entry.LastModified.After(latest[key]) {
+			latest[key] = entry.LastModified
+		}
+	}
+	byStreamer := make(map[string][]string)
+	for key := range latest {
+		streamer, _, _ := strings.Cut(key, "\x00")
+		byStreamer[streamer] = append(byStreamer[streamer], key)
+	}
+	for _, sessions := range byStreamer {
+		sort.Slice(sessions, func(i, j int) bool {
+			return latest[sessions[i]].After(latest[sessions[j]])
+		})
+		for _, key := range sessions[:min(r.keepSessions, len(sessions))] {
+			kept[key] = true
+		}
+	}
+	return kept
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ayumi-otosaka-314/brec-pp/config"
+)
+
+// fakeLister lists entries in the order given, and removes them from the list.
+type fakeLister struct {
+	entries   []*Entry
+	available uint64
+}
+
+func newFakeLister(entries ...*Entry) *fakeLister {
+	return &fakeLister{entries: entries}
+}
+
+func (l *fakeLister) GetAvailableCapacity() (uint64, error) { return l.available, nil }
+
+func (l *fakeLister) GetRemovables(ctx context.Context) (<-chan DoRemove, error) {
+	entries, err := l.ListEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Removables(ctx, entries), nil
+}
+
+func (l *fakeLister) ListEntries(ctx context.Context) ([]*Entry, error) {
+	result := make([]*Entry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		listed := *entry
+		listed.Remove = func() (uint64, error) {
+			return Remove(ctx, entry.Path, entry.Size, func() error {
+				for i, e := range l.entries {
+					if e == entry {
+						l.entries = append(l.entries[:i], l.entries[i+1:]...)
+						break
+					}
+				}
+				l.available += entry.Size
+				return nil
+			})
+		}
+		result = append(result, &listed)
+	}
+	return result, nil
+}
+
+func (l *fakeLister) paths() []string {
+	result := make([]string, 0, len(l.entries))
+	for _, entry := range l.entries {
+		result = append(result, entry.Path)
+	}
+	return result
+}
+
+func daysAgo(days int) time.Time {
+	return time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+}
+
+func TestNewRetention(t *testing.T) {
+	lister := newFakeLister()
+	assert.Same(t, lister, NewRetention(zaptest.NewLogger(t), lister, nil), "lister should be used as is without policy")
+}
+
+func Test_retention_EnsureCapacity(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     config.Retention
+		target   uint64
+		expected []string
+	}{
+		{
+			name:     "no rules",
+			target:   4,
+			expected: []string{"b/1.flv", "a/4.flv"},
+		},
+		{
+			name:     "max age",
+			conf:     config.Retention{MaxAge: 40 * time.Hour},
+			expected: []string{"a/4.flv"},
+		},
+		{
+			name:     "min age",
+			conf:     config.Retention{MinAge: 60 * time.Hour},
+			target:   10,
+			expected: []string{"a/3.flv", "b/1.flv", "a/4.flv"},
+		},
+		{
+			// entries of unknown sessions should not be kept as sessions.
+			name:     "keep sessions",
+			conf:     config.Retention{KeepSessions: 1},
+			target:   10,
+			expected: []string{"a/2.flv", "a/3.flv"},
+		},
+		{
+			name:     "streamer quota",
+			conf:     config.Retention{StreamerQuota: 5},
+			expected: []string{"b/1.flv", "a/4.flv"},
+		},
+		{
+			name:     "protected",
+			conf:     config.Retention{Protected: []string{"a/1.*", "b"}},
+			target:   10,
+			expected: []string{"a/1.flv", "b/1.flv"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lister := newFakeLister(
+				&Entry{Path: "a/1.flv", Size: 1, LastModified: daysAgo(4), Session: "s1"},
+				&Entry{Path: "a/2.flv", Size: 2, LastModified: daysAgo(3), Session: "s2"},
+				&Entry{Path: "a/3.flv", Size: 3, LastModified: daysAgo(2), Session: "s2"},
+				&Entry{Path: "b/1.flv", Size: 4, LastModified: daysAgo(2)},
+				&Entry{Path: "a/4.flv", Size: 5, LastModified: daysAgo(1)},
+			)
+			conf := tt.conf
+			err := EnsureCapacity(context.Background(), tt.target, NewRetention(zaptest.NewLogger(t), lister, &conf))
+			if lister.available < tt.target {
+				assert.ErrorIs(t, err, ErrCapacityNotEnsured, "capacity should not be ensured by removing protected entries")
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, lister.paths())
+		})
+	}
+}
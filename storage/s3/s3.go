@@ -0,0 +1,231 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/ayumi-otosaka-314/brec-pp/config"
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+	"github.com/ayumi-otosaka-314/brec-pp/upload"
+)
+
+// service implements upload.Uploader and storage.Cleaner for S3 compatible object storage.
+// As buckets usually have no capacity limit, capacity is calculated from configured quota.
+type service struct {
+	logger           *zap.Logger
+	client           *minio.Client
+	bucket           string
+	prefix           string
+	quota            uint64
+	reservedCapacity uint64
+	dryRun           bool
+	partSize         uint64
+	localRootPath    string
+}
+
+func NewUploader(
+	logger *zap.Logger,
+	s3Config *config.S3,
+	localRootPath string,
+) upload.Uploader {
+	svc, err := newService(logger, s3Config, localRootPath)
+	if err != nil {
+		panic(err)
+	}
+	return svc
+}
+
+func newService(logger *zap.Logger, s3Config *config.S3, localRootPath string) (*service, error) {
+	client, err := minio.New(s3Config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(s3Config.AccessKeyID, s3Config.SecretAccessKey, ""),
+		Secure: !s3Config.Insecure,
+		Region: s3Config.Region,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create s3 client")
+	}
+	return &service{
+		logger:           logger,
+		client:           client,
+		bucket:           s3Config.Bucket,
+		prefix:           s3Config.Prefix,
+		quota:            s3Config.Quota,
+		reservedCapacity: s3Config.ReservedCapacity,
+		dryRun:           s3Config.DryRun,
+		partSize:         s3Config.PartSize,
+		localRootPath:    localRootPath,
+	}, nil
+}
+
+func (s *service) Upload(ctx context.Context, job *upload.Job, _ upload.Checkpoint) error {
+	return classifyError(s.upload(ctx, job))
+}
+
+// classifyError inspects the minio error response of a failed put.
+// 4xx responses such as AccessDenied or NoSuchBucket are permanent, except SlowDown throttling.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return upload.Permanent(err)
+	}
+
+	resp := minio.ToErrorResponse(errors.Cause(err))
+	if resp.StatusCode < http.StatusBadRequest ||
+		resp.StatusCode >= http.StatusInternalServerError ||
+		resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode == http.StatusTooManyRequests ||
+		resp.Code == "SlowDown" {
+		return err
+	}
+	return upload.Permanent(err)
+}
+
+func (s *service) upload(ctx context.Context, job *upload.Job) error {
+	eventData := job.Event
+	if err := s.EnsureCapacity(ctx, s.reservedCapacity+eventData.FileSize); err != nil {
+		return errors.Wrap(err, "unable to ensure capacity")
+	}
+
+	uploadFile, err := os.Open(path.Join(s.localRootPath, eventData.RelativePath))
+	if err != nil {
+		return errors.Wrap(err, "unable to open uploadFile file")
+	}
+	defer uploadFile.Close()
+
+	info, err := uploadFile.Stat()
+	if err != nil {
+		return errors.Wrap(err, "unable to get uploadFile status")
+	}
+
+	// objects larger than part size are uploaded via multipart upload.
+	key := s.objectKey(eventData.RelativePath)
+	uploaded, err := s.client.PutObject(ctx, s.bucket, key, uploadFile, info.Size(), minio.PutObjectOptions{
+		ContentType: "video/x-flv",
+		PartSize:    s.partSize,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to upload file to s3")
+	}
+
+	s.logger.Debug("s3 upload completed",
+		zap.String("bucket", s.bucket), zap.String("key", uploaded.Key), zap.Int64("size", uploaded.Size))
+	return nil
+}
+
+// Verify compares size of the uploaded object with the local file.
+// Checksum is also compared for objects uploaded in a single part, whose ETag is the MD5 checksum of content.
+func (s *service) Verify(ctx context.Context, job *upload.Job) error {
+	filePath := path.Join(s.localRootPath, job.Event.RelativePath)
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return errors.Wrap(err, "unable to get local file status")
+	}
+
+	key := s.objectKey(job.Event.RelativePath)
+	object, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return errors.Wrap(err, "unable to get uploaded object status")
+	}
+	if err = storage.VerifySize(info.Size(), object.Size); err != nil {
+		return err
+	}
+
+	etag := strings.Trim(object.ETag, `"`)
+	if len(etag) != md5.Size*2 || strings.Contains(etag, "-") {
+		return nil
+	}
+	checksum, err := storage.MD5Checksum(filePath)
+	if err != nil {
+		return err
+	}
+	if err = storage.VerifyChecksum(checksum, etag); err != nil {
+		return err
+	}
+	job.Checksum = checksum
+	return nil
+}
+
+// objectKey returns the key of recording under prefix, keeping its path relative to local root path.
+func (s *service) objectKey(relativePath string) string {
+	return path.Join(s.prefix, filepath.ToSlash(relativePath))
+}
+
+func (s *service) GetAvailableCapacity() (uint64, error) {
+	objects, err := s.listObjects(context.Background())
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to get s3 usage")
+	}
+	var usage uint64
+	for _, object := range objects {
+		usage += uint64(object.Size)
+	}
+	if usage >= s.quota {
+		return 0, nil
+	}
+	return s.quota - usage, nil
+}
+
+// EnsureCapacity implements storage.CapacityEnsurer.
+func (s *service) EnsureCapacity(ctx context.Context, targetCapacity uint64) error {
+	return storage.EnsureCapacity(storage.DryRun(ctx, s.logger, s.dryRun), targetCapacity, s)
+}
+
+func (s *service) GetRemovables(ctx context.Context) (<-chan storage.DoRemove, error) {
+	objects, err := s.listObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].LastModified.Before(objects[j].LastModified)
+	})
+
+	entries := make([]*storage.Entry, 0, len(objects))
+	for _, object := range objects {
+		entries = append(entries, &storage.Entry{
+			Path:         object.Key,
+			Size:         uint64(object.Size),
+			LastModified: object.LastModified,
+			Remove: func() (uint64, error) {
+				return storage.Remove(ctx, object.Key, uint64(object.Size), func() error {
+					s.logger.Debug("deleting object from s3",
+						zap.String("bucket", s.bucket), zap.String("key", object.Key), zap.Int64("size", object.Size))
+					return s.client.RemoveObject(context.Background(), s.bucket, object.Key, minio.RemoveObjectOptions{})
+				})
+			},
+		})
+	}
+	return storage.Removables(ctx, entries), nil
+}
+
+func (s *service) listObjects(ctx context.Context) ([]minio.ObjectInfo, error) {
+	prefix := s.prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	objects := make([]minio.ObjectInfo, 0)
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return nil, errors.Wrap(object.Err, "error listing s3 objects")
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
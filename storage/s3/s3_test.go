@@ -0,0 +1,280 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+	"github.com/ayumi-otosaka-314/brec-pp/upload"
+)
+
+const testBucket = "recordings"
+
+func Test_service_Upload(t *testing.T) {
+	bucket := newFakeBucket()
+	bucket.put("archive/old.flv", []byte(strings.Repeat("x", 300)), time.Unix(1000, 0))
+	bucket.put("archive/older.flv", []byte(strings.Repeat("x", 300)), time.Unix(500, 0))
+	bucket.put("others/keep.flv", []byte(strings.Repeat("x", 100)), time.Unix(0, 0))
+	svc := newTestService(t, bucket)
+	svc.quota = 1000
+
+	content := strings.Repeat("0123456789", 50)
+	require.NoError(t, os.MkdirAll(path.Join(svc.localRootPath, "1001"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(svc.localRootPath, "1001/test.flv"), []byte(content), 0644))
+
+	job := &upload.Job{Event: &brec.EventDataFileClose{RelativePath: "1001/test.flv", FileSize: uint64(len(content))}}
+	require.NoError(t, svc.Upload(context.Background(), job, nil))
+
+	assert.Equal(t, []string{"archive/1001/test.flv", "archive/old.flv", "others/keep.flv"}, bucket.keys(),
+		"object should be keyed by relative path under prefix")
+	assert.Equal(t, content, string(bucket.get("archive/1001/test.flv")))
+
+	require.NoError(t, svc.Verify(context.Background(), job))
+	bucket.put("archive/1001/test.flv", []byte(strings.Repeat("x", len(content))), time.Now())
+	assert.Error(t, svc.Verify(context.Background(), job))
+}
+
+func Test_service_Upload_multipart(t *testing.T) {
+	bucket := newFakeBucket()
+	svc := newTestService(t, bucket)
+	svc.partSize = 5 * 1024 * 1024
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 12*1024*1024/16)
+	require.NoError(t, os.WriteFile(path.Join(svc.localRootPath, "test.flv"), content, 0644))
+
+	job := &upload.Job{Event: &brec.EventDataFileClose{RelativePath: "test.flv", FileSize: uint64(len(content))}}
+	require.NoError(t, svc.Upload(context.Background(), job, nil))
+
+	assert.Equal(t, content, bucket.get("archive/test.flv"))
+	assert.Equal(t, 3, bucket.partCount)
+	assert.NoError(t, svc.Verify(context.Background(), job))
+}
+
+func Test_classifyError(t *testing.T) {
+	for _, resp := range []minio.ErrorResponse{
+		{StatusCode: http.StatusForbidden, Code: "AccessDenied"},
+		{StatusCode: http.StatusNotFound, Code: "NoSuchBucket"},
+	} {
+		assert.True(t, upload.IsPermanent(classifyError(resp)), resp.Code)
+	}
+	for _, resp := range []minio.ErrorResponse{
+		{StatusCode: http.StatusServiceUnavailable, Code: "SlowDown"},
+		{StatusCode: http.StatusBadRequest, Code: "SlowDown"},
+		{StatusCode: http.StatusInternalServerError, Code: "InternalError"},
+	} {
+		assert.False(t, upload.IsPermanent(classifyError(resp)), resp.Code)
+	}
+}
+
+func Test_service_GetRemovables(t *testing.T) {
+	bucket := newFakeBucket()
+	bucket.put("archive/b.flv", []byte("bb"), time.Unix(2000, 0))
+	bucket.put("archive/a.flv", []byte("a"), time.Unix(1000, 0))
+	bucket.put("archive/c.flv", []byte("ccc"), time.Unix(3000, 0))
+	svc := newTestService(t, bucket)
+	svc.quota = 10
+
+	capacity, err := svc.GetAvailableCapacity()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), capacity)
+
+	require.NoError(t, storage.EnsureCapacity(context.Background(), 7, svc))
+	assert.Equal(t, []string{"archive/c.flv"}, bucket.keys())
+}
+
+func newTestService(t *testing.T, bucket *fakeBucket) *service {
+	server := httptest.NewTLSServer(bucket)
+	t.Cleanup(server.Close)
+
+	endpoint, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	client, err := minio.New(endpoint.Host, &minio.Options{
+		Creds:     credentials.NewStaticV4("accessKey", "secretKey", ""),
+		Secure:    true,
+		Region:    "us-east-1",
+		Transport: server.Client().Transport,
+	})
+	require.NoError(t, err)
+
+	return &service{
+		logger:        zap.NewNop(),
+		client:        client,
+		bucket:        testBucket,
+		prefix:        "archive",
+		quota:         1 << 30,
+		localRootPath: t.TempDir(),
+	}
+}
+
+// fakeBucket is an in-process stand-in of a single S3 bucket,
+// supporting only the requests issued by service.
+type fakeBucket struct {
+	mu        sync.Mutex
+	objects   map[string]fakeObject
+	parts     map[string]map[int][]byte
+	partCount int
+}
+
+type fakeObject struct {
+	content      []byte
+	etag         string
+	lastModified time.Time
+}
+
+func newFakeBucket() *fakeBucket {
+	return &fakeBucket{
+		objects: make(map[string]fakeObject),
+		parts:   make(map[string]map[int][]byte),
+	}
+}
+
+func (b *fakeBucket) put(key string, content []byte, lastModified time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[key] = fakeObject{content: content, etag: md5Hex(content), lastModified: lastModified}
+}
+
+func (b *fakeBucket) get(key string) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.objects[key].content
+}
+
+func (b *fakeBucket) keys() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := make([]string, 0, len(b.objects))
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+testBucket), "/")
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		b.list(w, query.Get("prefix"))
+	case r.Method == http.MethodHead:
+		object, ok := b.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.content)))
+		w.Header().Set("ETag", `"`+object.etag+`"`)
+		w.Header().Set("Last-Modified", object.lastModified.UTC().Format(http.TimeFormat))
+	case r.Method == http.MethodDelete:
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		uploadID := strconv.Itoa(len(b.parts) + 1)
+		b.parts[uploadID] = make(map[int][]byte)
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: testBucket, Key: key, UploadId: uploadID})
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts := b.parts[query.Get("uploadId")]
+		numbers := make([]int, 0, len(parts))
+		for number := range parts {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		var content []byte
+		for _, number := range numbers {
+			content = append(content, parts[number]...)
+		}
+		b.objects[key] = fakeObject{content: content, etag: "multipart-" + strconv.Itoa(len(numbers)), lastModified: time.Now()}
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: testBucket, Key: key, ETag: `"etag"`})
+	case r.Method == http.MethodPut:
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if uploadID := query.Get("uploadId"); uploadID != "" {
+			number, _ := strconv.Atoi(query.Get("partNumber"))
+			b.parts[uploadID][number] = content
+			b.partCount++
+			w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, len(content)))
+		} else {
+			b.objects[key] = fakeObject{content: content, etag: md5Hex(content), lastModified: time.Now()}
+			w.Header().Set("ETag", `"`+md5Hex(content)+`"`)
+		}
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (b *fakeBucket) list(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string
+		LastModified string
+		Size         int
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []content
+	}{Name: testBucket, Prefix: prefix}
+	for key, object := range b.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{
+				Key:          key,
+				LastModified: object.lastModified.UTC().Format(time.RFC3339),
+				Size:         len(object.content),
+			})
+		}
+	}
+	result.KeyCount = len(result.Contents)
+	writeXML(w, result)
+}
+
+func md5Hex(content []byte) string {
+	checksum := md5.Sum(content)
+	return hex.EncodeToString(checksum[:])
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(v)
+}
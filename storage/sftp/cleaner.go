@@ -0,0 +1,60 @@
+package sftp
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+)
+
+// cleaner implements storage.Cleaner.
+// It is used to clear old recordings under remote path to ensure capacity before uploading.
+type cleaner struct {
+	logger     *zap.Logger
+	client     *sftp.Client
+	remotePath string
+}
+
+// GetAvailableCapacity returns available bytes of remote file system via statvfs@openssh.com extension.
+func (c *cleaner) GetAvailableCapacity() (uint64, error) {
+	stat, err := c.client.StatVFS(c.remotePath)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to get sftp usage")
+	}
+	return stat.Bavail * stat.Frsize, nil
+}
+
+func (c *cleaner) GetRemovables(ctx context.Context) (<-chan storage.DoRemove, error) {
+	entries := make([]*storage.Entry, 0)
+	walker := c.client.Walk(c.remotePath)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, errors.Wrap(err, "error walking remote path")
+		}
+		info, filePath := walker.Stat(), walker.Path()
+		// partial files are uploads in progress or to be resumed, which should not be removed.
+		if !info.Mode().IsRegular() || strings.HasSuffix(filePath, partialSuffix) {
+			continue
+		}
+		entries = append(entries, &storage.Entry{
+			Path:         filePath,
+			Size:         uint64(info.Size()),
+			LastModified: info.ModTime(),
+			Remove: func() (uint64, error) {
+				return storage.Remove(ctx, filePath, uint64(info.Size()), func() error {
+					c.logger.Debug("deleting file from sftp", zap.String("path", filePath), zap.Int64("size", info.Size()))
+					return c.client.Remove(filePath)
+				})
+			},
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastModified.Before(entries[j].LastModified)
+	})
+	return storage.Removables(ctx, entries), nil
+}
@@ -0,0 +1,256 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/ayumi-otosaka-314/brec-pp/config"
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+	"github.com/ayumi-otosaka-314/brec-pp/upload"
+)
+
+// partialSuffix is appended to remote file name until upload is completed,
+// so that incomplete uploads could be told apart and resumed.
+const partialSuffix = ".part"
+
+type service struct {
+	logger           *zap.Logger
+	address          string
+	config           *ssh.ClientConfig
+	remotePath       string
+	reservedCapacity uint64
+	dryRun           bool
+	localRootPath    string
+}
+
+func NewUploader(
+	logger *zap.Logger,
+	sftpConfig *config.SFTP,
+	localRootPath string,
+) upload.Uploader {
+	conf, err := newClientConfig(sftpConfig)
+	if err != nil {
+		panic(err)
+	}
+	return &service{
+		logger:           logger,
+		address:          sftpConfig.Address,
+		config:           conf,
+		remotePath:       sftpConfig.RemotePath,
+		reservedCapacity: sftpConfig.ReservedCapacity,
+		dryRun:           sftpConfig.DryRun,
+		localRootPath:    localRootPath,
+	}
+}
+
+func newClientConfig(sftpConfig *config.SFTP) (*ssh.ClientConfig, error) {
+	hostKeyCallback, err := knownhosts.New(sftpConfig.KnownHostsPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading known hosts file at "+sftpConfig.KnownHostsPath)
+	}
+
+	auth := []ssh.AuthMethod{ssh.Password(sftpConfig.Password)}
+	if sftpConfig.PrivateKeyPath != "" {
+		signer, err := parsePrivateKey(sftpConfig.PrivateKeyPath, sftpConfig.PrivateKeyPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+	}
+	return &ssh.ClientConfig{
+		User:            sftpConfig.Username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sftpConfig.Timeout,
+	}, nil
+}
+
+func parsePrivateKey(keyPath, passphrase string) (ssh.Signer, error) {
+	b, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading private key file at "+keyPath)
+	}
+	var signer ssh.Signer
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(b, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(b)
+	}
+	return signer, errors.Wrap(err, "error parsing private key")
+}
+
+// EnsureCapacity implements storage.CapacityEnsurer.
+func (s *service) EnsureCapacity(ctx context.Context, targetCapacity uint64) error {
+	client, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return s.ensureCapacity(ctx, client, targetCapacity)
+}
+
+func (s *service) ensureCapacity(ctx context.Context, client *sftp.Client, targetCapacity uint64) error {
+	return storage.EnsureCapacity(
+		storage.DryRun(ctx, s.logger, s.dryRun),
+		targetCapacity,
+		&cleaner{logger: s.logger, client: client, remotePath: s.remotePath},
+	)
+}
+
+func (s *service) Upload(ctx context.Context, job *upload.Job, _ upload.Checkpoint) error {
+	return classifyError(s.upload(ctx, job))
+}
+
+// classifyError gives up when a local or remote path is missing or not permitted.
+// Everything else is assumed to be a dropped SSH connection and retried.
+func classifyError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return upload.Permanent(err)
+	}
+	return err
+}
+
+func (s *service) upload(ctx context.Context, job *upload.Job) error {
+	eventData := job.Event
+	client, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	uploadFile, err := os.Open(path.Join(s.localRootPath, eventData.RelativePath))
+	if err != nil {
+		return errors.Wrap(err, "unable to open uploadFile file")
+	}
+	defer uploadFile.Close()
+
+	info, err := uploadFile.Stat()
+	if err != nil {
+		return errors.Wrap(err, "unable to get uploadFile status")
+	}
+
+	remoteFilePath := path.Join(s.remotePath, eventData.RelativePath)
+	partialPath := remoteFilePath + partialSuffix
+	offset, err := s.remoteOffset(client, partialPath, info.Size())
+	if err != nil {
+		return err
+	}
+
+	if offset == 0 {
+		if err = s.ensureCapacity(ctx, client, s.reservedCapacity+eventData.FileSize); err != nil {
+			return errors.Wrap(err, "unable to ensure capacity")
+		}
+		if err = client.MkdirAll(path.Dir(remoteFilePath)); err != nil {
+			return errors.Wrap(err, "unable to create remote directory")
+		}
+	} else {
+		s.logger.Info("resuming sftp upload",
+			zap.String("path", remoteFilePath), zap.Int64("committed", offset))
+	}
+
+	remoteFile, err := client.OpenFile(partialPath, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return errors.Wrap(err, "unable to open remote file")
+	}
+	defer remoteFile.Close()
+
+	if _, err = remoteFile.Seek(offset, io.SeekStart); err != nil {
+		return errors.Wrap(err, "unable to seek remote file")
+	}
+	if _, err = uploadFile.Seek(offset, io.SeekStart); err != nil {
+		return errors.Wrap(err, "unable to seek uploadFile file")
+	}
+	if _, err = io.Copy(remoteFile, uploadFile); err != nil {
+		return errors.Wrap(err, "unable to upload file to sftp")
+	}
+	if err = remoteFile.Close(); err != nil {
+		return errors.Wrap(err, "unable to close remote file")
+	}
+	if err = client.PosixRename(partialPath, remoteFilePath); err != nil {
+		return errors.Wrap(err, "unable to rename remote file")
+	}
+
+	s.logger.Debug("sftp upload completed",
+		zap.String("path", remoteFilePath), zap.Int64("size", info.Size()))
+	return nil
+}
+
+// Verify compares size of the uploaded file with the local file.
+func (s *service) Verify(ctx context.Context, job *upload.Job) error {
+	info, err := os.Stat(path.Join(s.localRootPath, job.Event.RelativePath))
+	if err != nil {
+		return errors.Wrap(err, "unable to get local file status")
+	}
+
+	client, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	remoteInfo, err := client.Stat(path.Join(s.remotePath, job.Event.RelativePath))
+	if err != nil {
+		return errors.Wrap(err, "unable to get uploaded file status")
+	}
+	return storage.VerifySize(info.Size(), remoteInfo.Size())
+}
+
+// remoteOffset returns the size of partially uploaded file to resume from.
+// The upload restarts from the beginning if the partial file is not smaller than local file.
+func (s *service) remoteOffset(client *sftp.Client, partialPath string, size int64) (int64, error) {
+	info, err := client.Stat(partialPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to get remote file status")
+	}
+	if info.Size() >= size {
+		if err = client.Remove(partialPath); err != nil {
+			return 0, errors.Wrap(err, "unable to remove stale remote file")
+		}
+		return 0, nil
+	}
+	return info.Size(), nil
+}
+
+// connect establishes a sftp session, which would be closed when ctx is done.
+func (s *service) connect(ctx context.Context) (*sftp.Client, error) {
+	dialer := &net.Dialer{Timeout: s.config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to connect to "+s.address)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, s.address, s.config)
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, errors.Wrap(err, "unable to establish ssh connection")
+	}
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		stop()
+		sshClient.Close()
+		return nil, errors.Wrap(err, "unable to start sftp session")
+	}
+	go func() {
+		_ = client.Wait()
+		stop()
+		sshClient.Close()
+	}()
+	return client, nil
+}
@@ -0,0 +1,203 @@
+package sftp
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/config"
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+	"github.com/ayumi-otosaka-314/brec-pp/upload"
+)
+
+func Test_service_Upload(t *testing.T) {
+	svc := newTestService(t)
+	content := strings.Repeat("0123456789", 100)
+	relativePath := writeLocalFile(t, svc, "1001-测试/录制-1001.flv", content)
+
+	job := &upload.Job{Event: &brec.EventDataFileClose{RelativePath: relativePath, FileSize: uint64(len(content))}}
+	require.NoError(t, svc.Upload(context.Background(), job, nil))
+
+	uploaded, err := os.ReadFile(path.Join(svc.remotePath, relativePath))
+	require.NoError(t, err)
+	assert.Equal(t, content, string(uploaded))
+	assert.NoFileExists(t, path.Join(svc.remotePath, relativePath+partialSuffix))
+
+	require.NoError(t, svc.Verify(context.Background(), job))
+	require.NoError(t, os.WriteFile(path.Join(svc.remotePath, relativePath), []byte("truncated"), 0644))
+	assert.Error(t, svc.Verify(context.Background(), job))
+}
+
+func Test_service_Upload_resume(t *testing.T) {
+	svc := newTestService(t)
+	content := strings.Repeat("0123456789", 100)
+	relativePath := writeLocalFile(t, svc, "1001/test.flv", content)
+
+	// remote bytes are not verified on resume; only the remaining bytes would be uploaded.
+	partialPath := path.Join(svc.remotePath, relativePath+partialSuffix)
+	require.NoError(t, os.MkdirAll(path.Dir(partialPath), 0755))
+	require.NoError(t, os.WriteFile(partialPath, []byte(strings.Repeat("x", 300)), 0644))
+
+	require.NoError(t, svc.Upload(context.Background(), &upload.Job{Event: &brec.EventDataFileClose{RelativePath: relativePath, FileSize: uint64(len(content))}}, nil))
+
+	uploaded, err := os.ReadFile(path.Join(svc.remotePath, relativePath))
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("x", 300)+content[300:], string(uploaded))
+}
+
+func Test_service_Upload_missingFile(t *testing.T) {
+	svc := newTestService(t)
+
+	err := svc.Upload(context.Background(), &upload.Job{Event: &brec.EventDataFileClose{RelativePath: "missing.flv"}}, nil)
+	assert.True(t, upload.IsPermanent(err))
+}
+
+func Test_cleaner(t *testing.T) {
+	svc := newTestService(t)
+	for name, modTime := range map[string]time.Time{
+		"b.flv":      time.Unix(2000, 0),
+		"room/a.flv": time.Unix(1000, 0),
+		"room/c.flv": time.Unix(3000, 0),
+		// partial uploads should be kept, although the oldest.
+		"room/d.flv" + partialSuffix: time.Unix(500, 0),
+	} {
+		filePath := path.Join(svc.remotePath, name)
+		require.NoError(t, os.MkdirAll(path.Dir(filePath), 0755))
+		require.NoError(t, os.WriteFile(filePath, []byte("xxx"), 0644))
+		require.NoError(t, os.Chtimes(filePath, modTime, modTime))
+	}
+
+	client, err := svc.connect(context.Background())
+	require.NoError(t, err)
+	defer client.Close()
+	c := &cleaner{logger: zap.NewNop(), client: client, remotePath: svc.remotePath}
+
+	capacity, err := c.GetAvailableCapacity()
+	require.NoError(t, err)
+	assert.Positive(t, capacity)
+
+	removables, err := c.GetRemovables(context.Background())
+	require.NoError(t, err)
+	remove := <-removables
+	size, err := remove()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), size)
+
+	assert.NoFileExists(t, path.Join(svc.remotePath, "room/a.flv"))
+	assert.FileExists(t, path.Join(svc.remotePath, "b.flv"))
+	assert.FileExists(t, path.Join(svc.remotePath, "room/c.flv"))
+	assert.FileExists(t, path.Join(svc.remotePath, "room/d.flv"+partialSuffix))
+	require.NoError(t, storage.EnsureCapacity(context.Background(), 1, c))
+}
+
+// newTestService creates service against an in-process ssh server with sftp subsystem,
+// serving the local file system with password authentication.
+func newTestService(t *testing.T) *service {
+	server := startServer(t, "user", "password")
+	return NewUploader(zap.NewNop(), &config.SFTP{
+		Timeout:        time.Minute,
+		Address:        server.addr,
+		Username:       "user",
+		Password:       "password",
+		KnownHostsPath: server.knownHostsPath,
+		RemotePath:     t.TempDir(),
+	}, t.TempDir()).(*service)
+}
+
+type testServer struct {
+	addr           string
+	knownHostsPath string
+}
+
+func startServer(t *testing.T, user, password string) testServer {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	require.NoError(t, err)
+
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if conn.User() == user && string(pass) == password {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, serverConfig)
+		}
+	}()
+
+	knownHostsPath := path.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(listener.Addr().String())}, signer.PublicKey())
+	require.NoError(t, os.WriteFile(knownHostsPath, []byte(line+"\n"), 0644))
+	return testServer{addr: listener.Addr().String(), knownHostsPath: knownHostsPath}
+}
+
+func serveConn(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				isSFTP := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(isSFTP, nil)
+				if !isSFTP {
+					continue
+				}
+				server, err := sftp.NewServer(channel)
+				if err != nil {
+					channel.Close()
+					return
+				}
+				_ = server.Serve()
+				server.Close()
+				return
+			}
+		}()
+	}
+}
+
+func writeLocalFile(t *testing.T, svc *service, relativePath, content string) string {
+	filePath := path.Join(svc.localRootPath, relativePath)
+	require.NoError(t, os.MkdirAll(path.Dir(filePath), 0755))
+	require.NoError(t, os.WriteFile(filePath, []byte(content), 0644))
+	return relativePath
+}
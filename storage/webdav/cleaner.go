@@ -0,0 +1,198 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+)
+
+const (
+	propfindQuota = `<?xml version="1.0" encoding="utf-8"?>` +
+		`<d:propfind xmlns:d="DAV:"><d:prop><d:quota-available-bytes/></d:prop></d:propfind>`
+	propfindFiles = `<?xml version="1.0" encoding="utf-8"?>` +
+		`<d:propfind xmlns:d="DAV:"><d:prop>` +
+		`<d:resourcetype/><d:getcontentlength/><d:getlastmodified/>` +
+		`</d:prop></d:propfind>`
+)
+
+type multistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Propstats []struct {
+			Prop   prop   `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+type prop struct {
+	ResourceType struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+	ContentLength       string `xml:"DAV: getcontentlength"`
+	LastModified        string `xml:"DAV: getlastmodified"`
+	QuotaAvailableBytes string `xml:"DAV: quota-available-bytes"`
+}
+
+// resource is a member of a collection on the server.
+type resource struct {
+	// relativePath is the path relative to base URL, unescaped.
+	relativePath string
+	isCollection bool
+	size         uint64
+	lastModified time.Time
+}
+
+// GetAvailableCapacity returns quota-available-bytes of the base collection.
+// Capacity is considered unlimited if the server does not report quota.
+func (s *service) GetAvailableCapacity() (uint64, error) {
+	resources, err := s.propfind(context.Background(), "", "0", propfindQuota)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to get webdav quota")
+	}
+	if len(resources) == 0 {
+		return 0, errors.New("webdav quota missing in response")
+	}
+
+	quota := resources[0].prop.QuotaAvailableBytes
+	available, err := strconv.ParseInt(strings.TrimSpace(quota), 10, 64)
+	if quota == "" || err == nil && available < 0 {
+		s.logger.Debug("webdav quota not reported; assuming unlimited", zap.String("quota", quota))
+		return math.MaxUint64, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to parse webdav quota "+quota)
+	}
+	return uint64(available), nil
+}
+
+// EnsureCapacity implements storage.CapacityEnsurer.
+func (s *service) EnsureCapacity(ctx context.Context, targetCapacity uint64) error {
+	return storage.EnsureCapacity(storage.DryRun(ctx, s.logger, s.dryRun), targetCapacity, s)
+}
+
+func (s *service) GetRemovables(ctx context.Context) (<-chan storage.DoRemove, error) {
+	files := make([]resource, 0)
+	if err := s.listFiles(ctx, "", &files); err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].lastModified.Before(files[j].lastModified)
+	})
+
+	entries := make([]*storage.Entry, 0, len(files))
+	for _, file := range files {
+		entries = append(entries, &storage.Entry{
+			Path:         file.relativePath,
+			Size:         file.size,
+			LastModified: file.lastModified,
+			Remove: func() (uint64, error) {
+				return storage.Remove(ctx, file.relativePath, file.size, func() error {
+					s.logger.Debug("deleting file from webdav",
+						zap.String("path", file.relativePath), zap.Uint64("size", file.size))
+					req, err := s.newRequest(context.Background(), http.MethodDelete, file.relativePath, http.NoBody)
+					if err != nil {
+						return err
+					}
+					return s.do(req, nil)
+				})
+			},
+		})
+	}
+	return storage.Removables(ctx, entries), nil
+}
+
+// listFiles appends files under dir to result recursively.
+// Collections are traversed level by level, as infinite depth is often disabled by servers.
+func (s *service) listFiles(ctx context.Context, dir string, result *[]resource) error {
+	resources, err := s.propfind(ctx, dir+"/", "1", propfindFiles)
+	if err != nil {
+		return errors.Wrap(err, "unable to list webdav collection "+dir)
+	}
+	for _, r := range resources {
+		if r.relativePath == path.Clean(dir) {
+			continue
+		}
+		if r.isCollection {
+			if err = s.listFiles(ctx, r.relativePath, result); err != nil {
+				return err
+			}
+			continue
+		}
+		*result = append(*result, r.resource)
+	}
+	return nil
+}
+
+type propfindResource struct {
+	resource
+	prop prop
+}
+
+func (s *service) propfind(ctx context.Context, relativePath, depth, body string) ([]propfindResource, error) {
+	req, err := s.newRequest(ctx, "PROPFIND", relativePath, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", depth)
+
+	var ms multistatus
+	if err = s.do(req, func(r io.Reader) error {
+		return xml.NewDecoder(r).Decode(&ms)
+	}); err != nil {
+		return nil, err
+	}
+
+	resources := make([]propfindResource, 0, len(ms.Responses))
+	for _, response := range ms.Responses {
+		relative, err := s.relativePath(response.Href)
+		if err != nil {
+			return nil, err
+		}
+
+		r := propfindResource{resource: resource{relativePath: relative}}
+		for _, propstat := range response.Propstats {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			r.prop = propstat.Prop
+		}
+		r.isCollection = r.prop.ResourceType.Collection != nil
+		if r.prop.ContentLength != "" {
+			if r.size, err = strconv.ParseUint(r.prop.ContentLength, 10, 64); err != nil {
+				return nil, errors.Wrap(err, "invalid content length of "+relative)
+			}
+		}
+		if r.prop.LastModified != "" {
+			if r.lastModified, err = http.ParseTime(r.prop.LastModified); err != nil {
+				return nil, errors.Wrap(err, "invalid last modified time of "+relative)
+			}
+		}
+		resources = append(resources, r)
+	}
+	return resources, nil
+}
+
+// relativePath converts href in response to path relative to base URL.
+func (s *service) relativePath(href string) (string, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid href in webdav response "+href)
+	}
+	relative := strings.TrimPrefix(path.Clean(u.Path), path.Clean(s.baseURL.Path))
+	return path.Clean(strings.TrimPrefix(relative, "/")), nil
+}
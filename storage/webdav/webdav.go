@@ -0,0 +1,199 @@
+package webdav
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/ayumi-otosaka-314/brec-pp/config"
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+	"github.com/ayumi-otosaka-314/brec-pp/upload"
+)
+
+// service implements upload.Uploader and storage.Cleaner for WebDAV servers.
+// Recordings are uploaded under the configured collection, mirroring their relative path.
+type service struct {
+	logger           *zap.Logger
+	httpClient       *http.Client
+	baseURL          *url.URL
+	username         string
+	password         string
+	reservedCapacity uint64
+	dryRun           bool
+	localRootPath    string
+}
+
+func NewUploader(
+	logger *zap.Logger,
+	webdavConfig *config.WebDAV,
+	localRootPath string,
+) upload.Uploader {
+	baseURL, err := url.Parse(webdavConfig.URL)
+	if err != nil {
+		panic(errors.Wrap(err, "invalid webdav url"))
+	}
+	return &service{
+		logger:           logger,
+		httpClient:       &http.Client{},
+		baseURL:          baseURL,
+		username:         webdavConfig.Username,
+		password:         webdavConfig.Password,
+		reservedCapacity: webdavConfig.ReservedCapacity,
+		dryRun:           webdavConfig.DryRun,
+		localRootPath:    localRootPath,
+	}
+}
+
+func (s *service) Upload(ctx context.Context, job *upload.Job, _ upload.Checkpoint) error {
+	return classifyError(s.upload(ctx, job))
+}
+
+// statusError is returned when the server responds with an unexpected status code.
+type statusError struct {
+	method     string
+	statusCode int
+	status     string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected response of webdav %s: %s", e.method, e.status)
+}
+
+// classifyError gives up on 4xx statusError responses of the WebDAV server other than 408 and 429.
+// Transport errors without a response are retried.
+func classifyError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return upload.Permanent(err)
+	}
+
+	var statusErr *statusError
+	if !errors.As(err, &statusErr) ||
+		statusErr.statusCode >= http.StatusInternalServerError ||
+		statusErr.statusCode == http.StatusRequestTimeout ||
+		statusErr.statusCode == http.StatusTooManyRequests {
+		return err
+	}
+	return upload.Permanent(err)
+}
+
+func (s *service) upload(ctx context.Context, job *upload.Job) error {
+	eventData := job.Event
+	if err := s.EnsureCapacity(ctx, s.reservedCapacity+eventData.FileSize); err != nil {
+		return errors.Wrap(err, "unable to ensure capacity")
+	}
+
+	uploadFile, err := os.Open(path.Join(s.localRootPath, eventData.RelativePath))
+	if err != nil {
+		return errors.Wrap(err, "unable to open uploadFile file")
+	}
+	defer uploadFile.Close()
+
+	info, err := uploadFile.Stat()
+	if err != nil {
+		return errors.Wrap(err, "unable to get uploadFile status")
+	}
+
+	if err = s.makeCollections(ctx, path.Dir(eventData.RelativePath)); err != nil {
+		return errors.Wrap(err, "unable to create webdav collections")
+	}
+
+	req, err := s.newRequest(ctx, http.MethodPut, eventData.RelativePath, uploadFile)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "video/x-flv")
+	if err = s.do(req, nil); err != nil {
+		return errors.Wrap(err, "unable to upload file to webdav")
+	}
+
+	s.logger.Debug("webdav upload completed",
+		zap.String("path", eventData.RelativePath), zap.Int64("size", info.Size()))
+	return nil
+}
+
+// Verify compares size of the uploaded file with the local file.
+func (s *service) Verify(ctx context.Context, job *upload.Job) error {
+	info, err := os.Stat(path.Join(s.localRootPath, job.Event.RelativePath))
+	if err != nil {
+		return errors.Wrap(err, "unable to get local file status")
+	}
+
+	resources, err := s.propfind(ctx, job.Event.RelativePath, "0", propfindFiles)
+	if err != nil {
+		return errors.Wrap(err, "unable to get uploaded file status")
+	}
+	if len(resources) == 0 {
+		return errors.New("uploaded file missing in webdav response")
+	}
+	return storage.VerifySize(info.Size(), int64(resources[0].size))
+}
+
+// makeCollections creates collections of dir under base URL level by level, like `mkdir -p`.
+func (s *service) makeCollections(ctx context.Context, dir string) error {
+	current := ""
+	for _, name := range strings.Split(path.Clean(dir), "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		current = path.Join(current, name)
+
+		req, err := s.newRequest(ctx, "MKCOL", current+"/", http.NoBody)
+		if err != nil {
+			return err
+		}
+		// method not allowed is responded if the collection already exists.
+		var statusErr *statusError
+		if err = s.do(req, nil); errors.As(err, &statusErr) &&
+			statusErr.statusCode == http.StatusMethodNotAllowed {
+			continue
+		} else if err != nil {
+			return errors.Wrap(err, "error creating collection "+current)
+		}
+	}
+	return nil
+}
+
+// newRequest creates a request to relativePath under base URL.
+func (s *service) newRequest(ctx context.Context, method, relativePath string, body io.Reader) (*http.Request, error) {
+	target := s.baseURL.JoinPath(relativePath)
+	if strings.HasSuffix(relativePath, "/") && !strings.HasSuffix(target.Path, "/") {
+		target.Path += "/"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating webdav "+method+" request")
+	}
+	if s.username != "" || s.password != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	return req, nil
+}
+
+// do sends the request, and reads the response body with read if response is successful.
+func (s *service) do(req *http.Request, read func(io.Reader) error) error {
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error requesting webdav "+req.Method)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return &statusError{method: req.Method, statusCode: resp.StatusCode, status: resp.Status}
+	}
+	if read == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return read(resp.Body)
+}
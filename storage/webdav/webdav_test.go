@@ -0,0 +1,161 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/storage"
+	"github.com/ayumi-otosaka-314/brec-pp/upload"
+)
+
+func Test_service_Upload(t *testing.T) {
+	svc, remoteRoot := newTestService(t)
+	content := strings.Repeat("0123456789", 100)
+	relativePath := "1001-测试/录制-1001.flv"
+	require.NoError(t, os.MkdirAll(path.Join(svc.localRootPath, path.Dir(relativePath)), 0755))
+	require.NoError(t, os.WriteFile(path.Join(svc.localRootPath, relativePath), []byte(content), 0644))
+
+	job := &upload.Job{Event: &brec.EventDataFileClose{RelativePath: relativePath, FileSize: uint64(len(content))}}
+	require.NoError(t, svc.Upload(context.Background(), job, nil))
+	// collections already exist for the second upload.
+	require.NoError(t, svc.Upload(context.Background(), job, nil))
+
+	uploaded, err := os.ReadFile(path.Join(remoteRoot, relativePath))
+	require.NoError(t, err)
+	assert.Equal(t, content, string(uploaded))
+
+	require.NoError(t, svc.Verify(context.Background(), job))
+	require.NoError(t, os.WriteFile(path.Join(remoteRoot, relativePath), []byte("truncated"), 0644))
+	assert.Error(t, svc.Verify(context.Background(), job))
+}
+
+func Test_classifyError(t *testing.T) {
+	for statusCode, permanent := range map[int]bool{
+		http.StatusForbidden:           true,
+		http.StatusConflict:            true,
+		http.StatusRequestTimeout:      false,
+		http.StatusTooManyRequests:     false,
+		http.StatusInsufficientStorage: false,
+	} {
+		err := classifyError(&statusError{method: http.MethodPut, statusCode: statusCode})
+		assert.Equal(t, permanent, upload.IsPermanent(err), statusCode)
+	}
+	assert.False(t, upload.IsPermanent(classifyError(io.ErrUnexpectedEOF)))
+}
+
+func Test_service_GetRemovables(t *testing.T) {
+	svc, remoteRoot := newTestService(t)
+	svc.quotaAvailable = "4"
+	for name, modTime := range map[string]time.Time{
+		"b.flv":        time.Unix(2000, 0),
+		"room/a.flv":   time.Unix(1000, 0),
+		"room/c.flv":   time.Unix(3000, 0),
+		"room/x/d.flv": time.Unix(4000, 0),
+	} {
+		filePath := path.Join(remoteRoot, name)
+		require.NoError(t, os.MkdirAll(path.Dir(filePath), 0755))
+		require.NoError(t, os.WriteFile(filePath, []byte(strings.Repeat("x", 3)), 0644))
+		require.NoError(t, os.Chtimes(filePath, modTime, modTime))
+	}
+
+	capacity, err := svc.GetAvailableCapacity()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), capacity)
+
+	removables, err := svc.GetRemovables(context.Background())
+	require.NoError(t, err)
+	removed := 0
+	for remove := range removables {
+		size, err := remove()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(3), size)
+		if removed++; removed == 2 {
+			break
+		}
+	}
+
+	for name, exists := range map[string]bool{
+		"room/a.flv":   false,
+		"b.flv":        false,
+		"room/c.flv":   true,
+		"room/x/d.flv": true,
+	} {
+		_, err = os.Stat(path.Join(remoteRoot, name))
+		assert.Equal(t, exists, err == nil, name)
+	}
+}
+
+func Test_service_GetAvailableCapacity_unlimited(t *testing.T) {
+	svc, _ := newTestService(t)
+	for _, quota := range []string{"", "-3"} {
+		svc.quotaAvailable = quota
+		capacity, err := svc.GetAvailableCapacity()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1<<64-1), capacity)
+	}
+	svc.quotaAvailable = "42"
+	require.NoError(t, storage.EnsureCapacity(context.Background(), 42, svc))
+}
+
+type testService struct {
+	*service
+	quotaAvailable string
+}
+
+// newTestService creates service against an in-process webdav server, serving a temp directory under "/dav".
+// quota-available-bytes is not supported by the server, and is responded from testService.quotaAvailable.
+func newTestService(t *testing.T) (*testService, string) {
+	remoteRoot := t.TempDir()
+	svc := &testService{}
+	handler := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.Dir(remoteRoot),
+		LockSystem: webdav.NewMemLS(),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "user" || password != "password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if r.Method == "PROPFIND" && strings.Contains(string(body), "quota-available-bytes") {
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			w.WriteHeader(http.StatusMultiStatus)
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+				`<D:multistatus xmlns:D="DAV:"><D:response><D:href>/dav/</D:href><D:propstat>` +
+				`<D:prop><D:quota-available-bytes>` + svc.quotaAvailable + `</D:quota-available-bytes></D:prop>` +
+				`<D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response></D:multistatus>`))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	baseURL, err := url.Parse(server.URL + "/dav")
+	require.NoError(t, err)
+	svc.service = &service{
+		logger:        zap.NewNop(),
+		httpClient:    server.Client(),
+		baseURL:       baseURL,
+		username:      "user",
+		password:      "password",
+		localRootPath: t.TempDir(),
+	}
+	return svc, remoteRoot
+}
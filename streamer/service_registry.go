@@ -3,11 +3,21 @@ package streamer
 import (
 	"github.com/ayumi-otosaka-314/brec-pp/notification"
 	"github.com/ayumi-otosaka-314/brec-pp/storage"
+	"github.com/ayumi-otosaka-314/brec-pp/storage/localdrive"
 	"github.com/ayumi-otosaka-314/brec-pp/upload"
 )
 
 type ServiceRegistry interface {
 	GetNotifier(roomID uint64) notification.Service
-	GetLocalStorage(roomID uint64) storage.Cleaner
+	GetLocalStorage(roomID uint64) storage.TargetEnsurer
+	// GetLocalReserve returns the capacity reserved on local drive of the streamer before recording.
+	GetLocalReserve(roomID uint64) *localdrive.Reserve
+	// GetOpenFiles returns the recordings of the streamer being written, which are never removed from local drive.
+	GetOpenFiles(roomID uint64) *localdrive.OpenFiles
 	GetUploader(roomID uint64) upload.Service
+	// GetPinners returns the local drive and upload destinations of the streamer supporting pins.
+	GetPinners(roomID uint64) []storage.Pinner
+	// GetCapacityEnsurers returns the local drive, named "local", and upload destinations of the streamer
+	// ensuring capacity, by name; the destination configured inline is named "upload".
+	GetCapacityEnsurers(roomID uint64) map[string]storage.CapacityEnsurer
 }
@@ -0,0 +1,114 @@
+package upload
+
+import (
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/state"
+)
+
+// Archive is the progress of uploading a recording file to all destinations.
+type Archive struct {
+	ID    string                   `json:"id"`
+	Event *brec.EventDataFileClose `json:"event"`
+	// Uploaded are the durations of completed uploads by destination name.
+	Uploaded map[string]time.Duration `json:"uploaded"`
+	// Checksums are the checksums verified of completed uploads by destination name, if verified by checksum.
+	Checksums map[string]string `json:"checksums,omitempty"`
+	// Failed are the errors of uploads failed permanently by destination name.
+	Failed     map[string]string `json:"failed,omitempty"`
+	ReceivedAt time.Time         `json:"receivedAt"`
+	// ArchivedAt is the time when uploads to all required destinations are completed.
+	ArchivedAt time.Time `json:"archivedAt,omitempty"`
+	// FinishedAt is the time when uploads to all destinations are either completed or failed permanently,
+	// after the recording is archived.
+	FinishedAt time.Time `json:"finishedAt,omitempty"`
+}
+
+func newArchive(eventData *brec.EventDataFileClose) *Archive {
+	return &Archive{
+		ID:         eventData.RelativePath,
+		Event:      eventData,
+		Uploaded:   make(map[string]time.Duration),
+		Checksums:  make(map[string]string),
+		Failed:     make(map[string]string),
+		ReceivedAt: time.Now(),
+	}
+}
+
+// Archives persists upload progress of recordings of an entry in the state store.
+// Archives are kept for ttl once finished, and then pruned while putting.
+type Archives struct {
+	store  *state.Store
+	bucket string
+	pruner *state.Pruner
+}
+
+func NewArchives(store *state.Store, name string, ttl time.Duration) *Archives {
+	bucket := "uploadArchives/" + name
+	return &Archives{
+		store:  store,
+		bucket: bucket,
+		pruner: state.NewPruner(store, bucket, ttl, func(raw []byte) (bool, error) {
+			archive := &Archive{}
+			if err := jsoniter.Unmarshal(raw, archive); err != nil {
+				return false, errors.Wrap(err, "error unmarshalling upload archive")
+			}
+			return !archive.FinishedAt.IsZero() && time.Since(archive.FinishedAt) >= ttl, nil
+		}),
+	}
+}
+
+// Get loads the archive of recording at relativePath.
+// It returns false if no such archive exists.
+func (a *Archives) Get(relativePath string) (*Archive, bool, error) {
+	archive := &Archive{}
+	found, err := a.store.Get(a.bucket, relativePath, archive)
+	if !found || err != nil {
+		return nil, false, err
+	}
+	if archive.Uploaded == nil {
+		archive.Uploaded = make(map[string]time.Duration)
+	}
+	if archive.Checksums == nil {
+		archive.Checksums = make(map[string]string)
+	}
+	if archive.Failed == nil {
+		archive.Failed = make(map[string]string)
+	}
+	return archive, true, nil
+}
+
+func (a *Archives) Put(archive *Archive) error {
+	if err := a.pruner.Prune(); err != nil {
+		return errors.Wrap(err, "error pruning upload archives")
+	}
+	return a.store.Put(a.bucket, archive.ID, archive)
+}
+
+// IsArchived returns true if recording at relativePath has been uploaded to all required destinations.
+// Errors loading the archive are treated as not archived.
+func (a *Archives) IsArchived(relativePath string) bool {
+	archive, found, err := a.Get(relativePath)
+	return err == nil && found && !archive.ArchivedAt.IsZero()
+}
+
+// Recording returns the recording received at relativePath, or nil if it is unknown.
+func (a *Archives) Recording(relativePath string) *brec.EventDataFileClose {
+	archive, found, err := a.Get(relativePath)
+	if err != nil || !found {
+		return nil
+	}
+	return archive.Event
+}
+
+// IsPending returns true if recording at relativePath has been received but uploads to all destinations are not finished,
+// including recordings whose uploads to required destinations have failed.
+// Errors loading the archive are treated as pending, so that recordings are not removed by mistake.
+func (a *Archives) IsPending(relativePath string) bool {
+	archive, found, err := a.Get(relativePath)
+	return err != nil || (found && archive.FinishedAt.IsZero())
+}
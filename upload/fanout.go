@@ -0,0 +1,272 @@
+package upload
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/notification"
+)
+
+// Destination is an upload destination of a fan-out service.
+type Destination struct {
+	// Name identifies the destination; it must be unique and stable across restarts.
+	Name string
+	// Required destinations must succeed for a recording to be reported as archived.
+	Required bool
+	// NewService creates the upload service of the destination,
+	// which should report completed uploads to the notifier given.
+	NewService func(notifier notification.Service) Service
+}
+
+type fanOut struct {
+	logger       *zap.Logger
+	archives     *Archives
+	notifier     notification.Service
+	destinations []*fanOutDestination
+	onArchived   OnArchived
+
+	// mu guards read-modify-write of archives.
+	mu sync.Mutex
+}
+
+type fanOutDestination struct {
+	name     string
+	required bool
+	service  Service
+}
+
+// OnArchived is called once a recording is uploaded to all required destinations,
+// and uploads to all other destinations are either completed or failed permanently.
+// verified reports if uploads to all required destinations are verified by checksum.
+type OnArchived func(ctx context.Context, eventData *brec.EventDataFileClose, verified bool) error
+
+// NewFanOut creates an upload.Service which uploads every recording to all destinations.
+// Completion of each destination is persisted in archives, and the recording is reported to notifier
+// as upload completed only once uploads to all required destinations are completed,
+// and then to onArchived if not nil once uploads to optional destinations are finished as well.
+func NewFanOut(
+	logger *zap.Logger,
+	archives *Archives,
+	notifier notification.Service,
+	destinations []Destination,
+	onArchived OnArchived,
+) Service {
+	f := &fanOut{
+		logger:     logger,
+		archives:   archives,
+		notifier:   notifier,
+		onArchived: onArchived,
+	}
+	for _, destination := range destinations {
+		f.destinations = append(f.destinations, &fanOutDestination{
+			name:     destination.Name,
+			required: destination.Required,
+			service:  destination.NewService(&destinationNotifier{Service: notifier, fanOut: f, name: destination.Name}),
+		})
+	}
+	return f
+}
+
+// Submit persists the archive of the recording, and then submits it to all destinations.
+// The archive of the same recording submitted already is kept, so that completed destinations are not lost.
+func (f *fanOut) Submit(eventData *brec.EventDataFileClose) error {
+	if err := f.putArchive(eventData); err != nil {
+		return err
+	}
+	for _, destination := range f.destinations {
+		if err := destination.service.Submit(eventData); err != nil {
+			return errors.Wrapf(err, "error submitting upload to destination [%s]", destination.name)
+		}
+	}
+	return nil
+}
+
+func (f *fanOut) Close() {
+	for _, destination := range f.destinations {
+		destination.service.Close()
+	}
+}
+
+func (f *fanOut) putArchive(eventData *brec.EventDataFileClose) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	archive, found, err := f.archives.Get(eventData.RelativePath)
+	if err != nil {
+		return errors.Wrap(err, "error loading upload archive")
+	}
+	if found && sameRecording(archive.Event, eventData) {
+		return nil
+	}
+	return errors.Wrap(f.archives.Put(newArchive(eventData)), "error persisting upload archive")
+}
+
+// onUploadComplete records completion of the destination, and reports the recording as upload completed
+// once all required destinations are completed.
+func (f *fanOut) onUploadComplete(
+	ctx context.Context,
+	timestamp time.Time,
+	eventData *brec.EventDataFileClose,
+	destination string,
+	uploadDuration time.Duration,
+	checksum string,
+) error {
+	archive, archived, finished := f.record(timestamp, eventData, destination, func(archive *Archive) {
+		archive.Uploaded[destination] = uploadDuration
+		archive.Checksums[destination] = checksum
+		if checksum == "" {
+			delete(archive.Checksums, destination)
+		}
+		delete(archive.Failed, destination)
+	})
+
+	var err error
+	if archived {
+		// the longest upload among destinations is reported as the upload duration.
+		var longest time.Duration
+		for _, d := range archive.Uploaded {
+			longest = max(longest, d)
+		}
+		err = f.notifier.OnUploadComplete(ctx, timestamp, eventData, longest)
+	} else {
+		f.logger.Debug("upload to destination completed", zap.String("filePath", eventData.RelativePath),
+			zap.String("destination", destination), zap.Int("uploaded", len(archive.Uploaded)))
+	}
+	if finished {
+		f.finish(ctx, archive)
+	}
+	return err
+}
+
+// onUploadFailed records permanent failure of the destination, which has been alerted by the destination already.
+func (f *fanOut) onUploadFailed(ctx context.Context, eventData *brec.EventDataFileClose, destination string, err error) {
+	archive, _, finished := f.record(time.Now(), eventData, destination, func(archive *Archive) {
+		archive.Failed[destination] = err.Error()
+	})
+	if finished {
+		f.finish(ctx, archive)
+	}
+}
+
+// finish calls onArchived once uploads to all destinations are finished,
+// so that the recording is not moved or deleted while any destination is still uploading it.
+func (f *fanOut) finish(ctx context.Context, archive *Archive) {
+	if f.onArchived == nil {
+		return
+	}
+	eventData := archive.Event
+	if err := f.onArchived(ctx, eventData, f.isVerified(archive)); err != nil {
+		f.logger.Error("error handling archived recording", zap.Error(err),
+			zap.String("filePath", eventData.RelativePath))
+		f.notifier.Alert(ctx, "error handling archived recording "+eventData.RelativePath, err)
+	}
+}
+
+// record persists the update of the destination to the archive.
+// It returns whether the recording becomes archived, and whether uploads to all destinations become finished,
+// by this update.
+func (f *fanOut) record(
+	timestamp time.Time,
+	eventData *brec.EventDataFileClose,
+	destination string,
+	update func(*Archive),
+) (*Archive, bool, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	archive, found, err := f.archives.Get(eventData.RelativePath)
+	if err != nil {
+		f.logger.Warn("error loading upload archive", zap.Error(err), zap.String("filePath", eventData.RelativePath))
+	}
+	if !found || err != nil {
+		archive = newArchive(eventData)
+	}
+	update(archive)
+
+	archived := archive.ArchivedAt.IsZero() && f.isArchived(archive)
+	if archived {
+		archive.ArchivedAt = timestamp
+	}
+	finished := !archive.ArchivedAt.IsZero() && archive.FinishedAt.IsZero() && f.isFinished(archive)
+	if finished {
+		archive.FinishedAt = timestamp
+	}
+	if err = f.archives.Put(archive); err != nil {
+		f.logger.Error("error persisting upload archive", zap.Error(err),
+			zap.String("filePath", eventData.RelativePath), zap.String("destination", destination))
+	}
+	return archive, archived, finished
+}
+
+func (f *fanOut) isArchived(archive *Archive) bool {
+	for _, destination := range f.destinations {
+		if _, ok := archive.Uploaded[destination.name]; destination.required && !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// isVerified reports if uploads to all required destinations are verified by checksum.
+func (f *fanOut) isVerified(archive *Archive) bool {
+	for _, destination := range f.destinations {
+		if _, ok := archive.Checksums[destination.name]; destination.required && !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// isFinished reports if uploads to all destinations are either completed or failed permanently.
+func (f *fanOut) isFinished(archive *Archive) bool {
+	for _, destination := range f.destinations {
+		_, uploaded := archive.Uploaded[destination.name]
+		_, failed := archive.Failed[destination.name]
+		if !uploaded && !failed {
+			return false
+		}
+	}
+	return true
+}
+
+// destinationNotifier intercepts upload completion of a destination to track archive progress.
+type destinationNotifier struct {
+	notification.Service
+	fanOut *fanOut
+	name   string
+}
+
+func (n *destinationNotifier) OnJobDone(
+	ctx context.Context,
+	timestamp time.Time,
+	job *Job,
+	uploadDuration time.Duration,
+) error {
+	return n.fanOut.onUploadComplete(ctx, timestamp, job.Event, n.name, uploadDuration, job.Checksum)
+}
+
+func (n *destinationNotifier) OnJobFailed(ctx context.Context, job *Job, err error) {
+	n.fanOut.onUploadFailed(ctx, job.Event, n.name, err)
+}
+
+// OnUploadComplete records completion of the destination not verified by checksum.
+func (n *destinationNotifier) OnUploadComplete(
+	ctx context.Context,
+	timestamp time.Time,
+	eventData *brec.EventDataFileClose,
+	uploadDuration time.Duration,
+) error {
+	return n.fanOut.onUploadComplete(ctx, timestamp, eventData, n.name, uploadDuration, "")
+}
+
+func (n *destinationNotifier) Alert(ctx context.Context, msg string, err error) {
+	if n.name != "" {
+		msg = "[" + n.name + "] " + msg
+	}
+	n.Service.Alert(ctx, msg, err)
+}
@@ -0,0 +1,167 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/notification"
+	"github.com/ayumi-otosaka-314/brec-pp/state"
+)
+
+func TestFanOut(t *testing.T) {
+	store, err := state.Open(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	notifier := &fakeNotifier{completed: make(chan time.Duration, 1)}
+	destinations := map[string]*fakeDestination{}
+	newDestination := func(name string, required bool) Destination {
+		return Destination{
+			Name:     name,
+			Required: required,
+			NewService: func(notifier notification.Service) Service {
+				d := &fakeDestination{notifier: notifier}
+				destinations[name] = d
+				return d
+			},
+		}
+	}
+	archived := make(chan *brec.EventDataFileClose, 1)
+	onArchived := func(_ context.Context, eventData *brec.EventDataFileClose, verified bool) error {
+		assert.False(t, verified, "uploads without checksum should not be verified")
+		archived <- eventData
+		return nil
+	}
+	archives := NewArchives(store, "test", time.Hour)
+	svc := NewFanOut(zaptest.NewLogger(t), archives, notifier, []Destination{
+		newDestination("gdrive", true),
+		newDestination("s3", true),
+		newDestination("mirror", false),
+	}, onArchived)
+
+	eventData := &brec.EventDataFileClose{RelativePath: "room/test.flv"}
+	require.NoError(t, svc.Submit(eventData))
+	assert.True(t, archives.IsPending(eventData.RelativePath), "archive should be persisted once submitted")
+	for _, d := range destinations {
+		assert.Equal(t, []*brec.EventDataFileClose{eventData}, d.submitted)
+	}
+
+	complete := func(name string, uploadDuration time.Duration) {
+		require.NoError(t, destinations[name].notifier.OnUploadComplete(
+			context.Background(), time.Now(), eventData, uploadDuration,
+		))
+	}
+	complete("gdrive", 2*time.Second)
+	require.NoError(t, svc.Submit(eventData))
+	archive, _, err := archives.Get(eventData.RelativePath)
+	require.NoError(t, err)
+	assert.Contains(t, archive.Uploaded, "gdrive", "completed destinations should be kept when submitted again")
+	complete("mirror", 5*time.Second)
+	assert.Empty(t, notifier.completed, "should not be reported before all required destinations complete")
+	assert.Empty(t, archived)
+	assert.False(t, archives.IsArchived(eventData.RelativePath))
+	assert.True(t, archives.IsPending(eventData.RelativePath))
+
+	complete("s3", time.Second)
+	assert.Equal(t, 5*time.Second, <-notifier.completed)
+	assert.Equal(t, eventData, <-archived)
+
+	// completion of destinations after archived should not be reported again.
+	complete("mirror", time.Second)
+	assert.Empty(t, notifier.completed)
+	assert.Empty(t, archived)
+
+	archive, found, err := archives.Get(eventData.RelativePath)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, archives.IsArchived(eventData.RelativePath))
+	assert.False(t, archives.IsPending(eventData.RelativePath))
+	assert.Len(t, archive.Uploaded, 3)
+}
+
+func TestFanOut_optionalFinishedLast(t *testing.T) {
+	for name, finishOptional := range map[string]func(notifier notification.Service, eventData *brec.EventDataFileClose){
+		"completed": func(notifier notification.Service, eventData *brec.EventDataFileClose) {
+			require.NoError(t, notifier.OnUploadComplete(context.Background(), time.Now(), eventData, time.Second))
+		},
+		"failed": func(notifier notification.Service, eventData *brec.EventDataFileClose) {
+			notifier.(jobNotifier).OnJobFailed(context.Background(), &Job{Event: eventData}, errors.New("permanent"))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			store, err := state.Open(t.TempDir())
+			require.NoError(t, err)
+			defer store.Close()
+
+			notifiers := map[string]notification.Service{}
+			newDestination := func(name string, required bool) Destination {
+				return Destination{
+					Name:     name,
+					Required: required,
+					NewService: func(notifier notification.Service) Service {
+						notifiers[name] = notifier
+						return &fakeDestination{}
+					},
+				}
+			}
+			archived := make(chan *brec.EventDataFileClose, 1)
+			archives := NewArchives(store, "test", time.Hour)
+			notifier := &fakeNotifier{completed: make(chan time.Duration, 1)}
+			svc := NewFanOut(zaptest.NewLogger(t), archives, notifier, []Destination{
+				newDestination("gdrive", true),
+				newDestination("mirror", false),
+			}, func(_ context.Context, eventData *brec.EventDataFileClose, verified bool) error {
+				assert.True(t, verified, "required destination should be verified by checksum")
+				archived <- eventData
+				return nil
+			})
+
+			eventData := &brec.EventDataFileClose{RelativePath: "room/test.flv"}
+			require.NoError(t, svc.Submit(eventData))
+			require.NoError(t, notifiers["gdrive"].(jobNotifier).OnJobDone(context.Background(), time.Now(),
+				&Job{Event: eventData, Checksum: "checksum"}, time.Second))
+			assert.Equal(t, time.Second, <-notifier.completed)
+			assert.True(t, archives.IsArchived(eventData.RelativePath))
+			assert.Empty(t, archived, "should not be handled while optional destination is unfinished")
+			assert.True(t, archives.IsPending(eventData.RelativePath))
+
+			finishOptional(notifiers["mirror"], eventData)
+			assert.Equal(t, eventData, <-archived)
+			assert.False(t, archives.IsPending(eventData.RelativePath))
+		})
+	}
+}
+
+type fakeDestination struct {
+	notifier  notification.Service
+	submitted []*brec.EventDataFileClose
+}
+
+func (d *fakeDestination) Submit(eventData *brec.EventDataFileClose) error {
+	d.submitted = append(d.submitted, eventData)
+	return nil
+}
+
+func (d *fakeDestination) Close() {}
+
+type fakeNotifier struct {
+	notification.Service
+	completed chan time.Duration
+}
+
+func (n *fakeNotifier) OnUploadComplete(
+	_ context.Context,
+	_ time.Time,
+	_ *brec.EventDataFileClose,
+	uploadDuration time.Duration,
+) error {
+	n.completed <- uploadDuration
+	return nil
+}
@@ -1,7 +1,40 @@
 package upload
 
-import "github.com/ayumi-otosaka-314/brec-pp/brec"
+import (
+	"context"
+	"time"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+)
 
 type Service interface {
-	Receive() chan<- *brec.EventDataFileClose
+	// Submit persists upload of the recording file and schedules it, so that it is resumed after restart.
+	// An error is returned if the upload could not be persisted; submitting the same recording again is a no-op.
+	Submit(*brec.EventDataFileClose) error
+	// Close stops uploading, cancelling uploads in progress and waiting for them to return.
+	// Unfinished jobs are kept in the queue to be resumed on next creation.
+	Close()
+}
+
+// Uploader uploads a single recording file to its destination.
+// The upload should be resumed from Job.ResumeToken and Job.CommittedBytes if they are set,
+// and progress should be reported via Checkpoint so that it could be resumed later.
+type Uploader interface {
+	Upload(context.Context, *Job, Checkpoint) error
+}
+
+// Verifier is implemented by uploaders able to verify that the uploaded file matches the local file,
+// by size and checksum if available. The upload is considered failed if verification fails.
+type Verifier interface {
+	Verify(context.Context, *Job) error
+}
+
+// jobNotifier is implemented by notifiers of upload services tracking jobs finished,
+// which are notified of jobs done instead of OnUploadComplete, and of jobs failed permanently after alerted.
+type jobNotifier interface {
+	OnJobDone(ctx context.Context, timestamp time.Time, job *Job, uploadDuration time.Duration) error
+	OnJobFailed(ctx context.Context, job *Job, err error)
 }
+
+// Checkpoint persists the progress of an upload job.
+type Checkpoint func(resumeToken string, committedBytes uint64) error
@@ -0,0 +1,133 @@
+package upload
+
+import (
+	"sort"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/state"
+)
+
+type JobState string
+
+const (
+	JobStatePending    JobState = "pending"
+	JobStateInProgress JobState = "inProgress"
+	JobStateDone       JobState = "done"
+	JobStateFailed     JobState = "failed"
+)
+
+// Job is an upload of a single recording file to a single destination.
+type Job struct {
+	ID        string                   `json:"id"`
+	State     JobState                 `json:"state"`
+	Event     *brec.EventDataFileClose `json:"event"`
+	CreatedAt time.Time                `json:"createdAt"`
+	UpdatedAt time.Time                `json:"updatedAt"`
+	LastError string                   `json:"lastError,omitempty"`
+
+	// Attempts are the failed attempts of the job.
+	Attempts []Attempt `json:"attempts,omitempty"`
+	// NextAttemptAt is the earliest time for the job to be attempted again after failure.
+	NextAttemptAt time.Time `json:"nextAttemptAt,omitempty"`
+
+	// ResumeToken is the destination specific token to resume a partially completed upload,
+	// e.g. the session URI of a resumable upload.
+	ResumeToken string `json:"resumeToken,omitempty"`
+	// CommittedBytes is the count of bytes acknowledged by the destination under ResumeToken.
+	CommittedBytes uint64 `json:"committedBytes,omitempty"`
+	// Verified is true if the uploaded file is verified against the local file by the uploader.
+	Verified bool `json:"verified,omitempty"`
+	// Checksum is the hex encoded checksum of the uploaded file, if verified by checksum;
+	// it is MD5 for remote destinations, and SHA-256 for local mirror.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// Queue persists upload jobs of one destination in the state store,
+// so that unfinished jobs could be resumed after restart.
+// Finished jobs are kept for ttl, to ignore recordings submitted again, and then pruned while enqueueing.
+type Queue struct {
+	store  *state.Store
+	bucket string
+	pruner *state.Pruner
+}
+
+func NewQueue(store *state.Store, destination string, ttl time.Duration) *Queue {
+	bucket := "uploadJobs/" + destination
+	return &Queue{
+		store:  store,
+		bucket: bucket,
+		pruner: state.NewPruner(store, bucket, ttl, func(raw []byte) (bool, error) {
+			job := &Job{}
+			if err := jsoniter.Unmarshal(raw, job); err != nil {
+				return false, errors.Wrap(err, "error unmarshalling upload job")
+			}
+			return (job.State == JobStateDone || job.State == JobStateFailed) && time.Since(job.UpdatedAt) >= ttl, nil
+		}),
+	}
+}
+
+// Enqueue records a pending job for the closed recording file.
+// Existing job of the same file would be overwritten.
+func (q *Queue) Enqueue(eventData *brec.EventDataFileClose) (*Job, error) {
+	if err := q.pruner.Prune(); err != nil {
+		return nil, errors.Wrap(err, "error pruning finished upload jobs")
+	}
+	now := time.Now()
+	job := &Job{
+		ID:        eventData.RelativePath,
+		State:     JobStatePending,
+		Event:     eventData,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	return job, q.store.Put(q.bucket, job.ID, job)
+}
+
+// Get loads the job of recording at relativePath.
+// It returns false if no such job exists.
+func (q *Queue) Get(relativePath string) (*Job, bool, error) {
+	job := &Job{}
+	found, err := q.store.Get(q.bucket, relativePath, job)
+	if !found || err != nil {
+		return nil, false, err
+	}
+	return job, true, nil
+}
+
+func (q *Queue) Update(job *Job) error {
+	job.UpdatedAt = time.Now()
+	return q.store.Put(q.bucket, job.ID, job)
+}
+
+// Unfinished returns pending and in-progress jobs, ordered by creation time.
+func (q *Queue) Unfinished() ([]*Job, error) {
+	jobs := make([]*Job, 0)
+	if err := q.store.ForEach(q.bucket, func(_ string, raw []byte) error {
+		job := &Job{}
+		if err := jsoniter.Unmarshal(raw, job); err != nil {
+			return errors.Wrap(err, "error unmarshalling upload job")
+		}
+		if job.State == JobStatePending || job.State == JobStateInProgress {
+			jobs = append(jobs, job)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
+
+// sameRecording reports if both events are of the same recording file closed,
+// i.e. one is redelivered by the recorder.
+func sameRecording(a, b *brec.EventDataFileClose) bool {
+	return a != nil && b != nil &&
+		a.RelativePath == b.RelativePath && a.FileCloseTime == b.FileCloseTime && a.FileSize == b.FileSize
+}
@@ -0,0 +1,80 @@
+package upload
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/state"
+)
+
+func TestQueue_Unfinished(t *testing.T) {
+	dir := t.TempDir()
+	store, err := state.Open(dir)
+	require.NoError(t, err)
+
+	q := NewQueue(store, "test", time.Hour)
+	pending, err := q.Enqueue(&brec.EventDataFileClose{RelativePath: "room/pending.flv"})
+	require.NoError(t, err)
+	inProgress, err := q.Enqueue(&brec.EventDataFileClose{RelativePath: "room/inProgress.flv"})
+	require.NoError(t, err)
+	inProgress.State = JobStateInProgress
+	require.NoError(t, q.Update(inProgress))
+	done, err := q.Enqueue(&brec.EventDataFileClose{RelativePath: "room/done.flv"})
+	require.NoError(t, err)
+	done.State = JobStateDone
+	require.NoError(t, q.Update(done))
+
+	_, err = NewQueue(store, "other", time.Hour).Enqueue(&brec.EventDataFileClose{RelativePath: "room/other.flv"})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	// reopen to ensure jobs survive restart
+	store, err = state.Open(dir)
+	require.NoError(t, err)
+	defer store.Close()
+
+	jobs, err := NewQueue(store, "test", time.Hour).Unfinished()
+	require.NoError(t, err)
+	if assert.Len(t, jobs, 2) {
+		assert.Equal(t, pending.ID, jobs[0].ID)
+		assert.Equal(t, JobStatePending, jobs[0].State)
+		assert.Equal(t, inProgress.ID, jobs[1].ID)
+		assert.Equal(t, JobStateInProgress, jobs[1].State)
+		assert.Equal(t, "room/inProgress.flv", jobs[1].Event.RelativePath)
+	}
+}
+
+func TestQueue_prune(t *testing.T) {
+	store, err := state.Open(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	expired := time.Now().Add(-2 * time.Hour)
+	for _, job := range []*Job{
+		{ID: "room/done.flv", State: JobStateDone, UpdatedAt: expired},
+		{ID: "room/failed.flv", State: JobStateFailed, UpdatedAt: expired},
+		{ID: "room/pending.flv", State: JobStatePending, UpdatedAt: expired},
+		{ID: "room/recent.flv", State: JobStateDone, UpdatedAt: time.Now()},
+	} {
+		require.NoError(t, store.Put("uploadJobs/test", job.ID, job))
+	}
+
+	q := NewQueue(store, "test", time.Hour)
+	_, err = q.Enqueue(&brec.EventDataFileClose{RelativePath: "room/new.flv"})
+	require.NoError(t, err)
+	for path, kept := range map[string]bool{
+		"room/done.flv":    false,
+		"room/failed.flv":  false,
+		"room/pending.flv": true,
+		"room/recent.flv":  true,
+		"room/new.flv":     true,
+	} {
+		_, found, err := q.Get(path)
+		require.NoError(t, err)
+		assert.Equal(t, kept, found, path)
+	}
+}
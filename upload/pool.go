@@ -0,0 +1,113 @@
+package upload
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+)
+
+type Ordering string
+
+const (
+	// OrderingFIFO uploads jobs in the order they are received.
+	OrderingFIFO Ordering = "fifo"
+	// OrderingOldestFirst uploads jobs of older recordings first.
+	OrderingOldestFirst Ordering = "oldestFirst"
+
+	defaultConcurrency = 2
+)
+
+// pool holds jobs ready to be uploaded, and hands them out to workers by ordering.
+type pool struct {
+	mu     sync.Mutex
+	ready  *sync.Cond
+	jobs   *jobHeap
+	closed bool
+}
+
+func newPool(ordering Ordering) *pool {
+	p := &pool{jobs: &jobHeap{less: lessFunc(ordering)}}
+	p.ready = sync.NewCond(&p.mu)
+	return p
+}
+
+// schedule pushes job to the pool, after its NextAttemptAt if in future.
+func (p *pool) schedule(job *Job) {
+	if wait := time.Until(job.NextAttemptAt); wait > 0 {
+		time.AfterFunc(wait, func() { p.push(job) })
+		return
+	}
+	p.push(job)
+}
+
+func (p *pool) push(job *Job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	heap.Push(p.jobs, job)
+	p.ready.Signal()
+}
+
+// next blocks until a job is available, or returns nil once the pool is closed.
+func (p *pool) next() *Job {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.jobs.Len() == 0 && !p.closed {
+		p.ready.Wait()
+	}
+	if p.closed {
+		return nil
+	}
+	return heap.Pop(p.jobs).(*Job)
+}
+
+// close wakes up all workers waiting for jobs, and stops handing out jobs.
+func (p *pool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.ready.Broadcast()
+}
+
+func lessFunc(ordering Ordering) func(a, b *Job) bool {
+	if ordering == OrderingOldestFirst {
+		return func(a, b *Job) bool {
+			aTime, bTime := recordedAt(a), recordedAt(b)
+			if aTime.Equal(bTime) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+			return aTime.Before(bTime)
+		}
+	}
+	return func(a, b *Job) bool {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+}
+
+// recordedAt returns the time when recording file of the job is opened,
+// falling back to the job creation time.
+func recordedAt(job *Job) time.Time {
+	if t, err := time.Parse(brec.TimestampLayout, job.Event.FileOpenTime); err == nil {
+		return t
+	}
+	return job.CreatedAt
+}
+
+// jobHeap implements heap.Interface.
+type jobHeap struct {
+	jobs []*Job
+	less func(a, b *Job) bool
+}
+
+func (h *jobHeap) Len() int           { return len(h.jobs) }
+func (h *jobHeap) Less(i, j int) bool { return h.less(h.jobs[i], h.jobs[j]) }
+func (h *jobHeap) Swap(i, j int)      { h.jobs[i], h.jobs[j] = h.jobs[j], h.jobs[i] }
+func (h *jobHeap) Push(x any)         { h.jobs = append(h.jobs, x.(*Job)) }
+
+func (h *jobHeap) Pop() any {
+	last := h.jobs[len(h.jobs)-1]
+	h.jobs[len(h.jobs)-1] = nil
+	h.jobs = h.jobs[:len(h.jobs)-1]
+	return last
+}
@@ -0,0 +1,50 @@
+package upload
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+)
+
+func Test_pool_ordering(t *testing.T) {
+	now := time.Now()
+	newJob := func(id string, createdAt time.Time, fileOpenTime string) *Job {
+		return &Job{
+			ID:        id,
+			CreatedAt: createdAt,
+			Event:     &brec.EventDataFileClose{RelativePath: id, FileOpenTime: fileOpenTime},
+		}
+	}
+	jobs := []*Job{
+		newJob("first", now, "2024-05-02T20:00:00.0000000+08:00"),
+		newJob("second", now.Add(time.Second), "2024-05-01T20:00:00.0000000+08:00"),
+		newJob("third", now.Add(2*time.Second), ""),
+	}
+
+	for ordering, expected := range map[Ordering][]string{
+		OrderingFIFO:        {"first", "second", "third"},
+		OrderingOldestFirst: {"second", "first", "third"},
+	} {
+		p := newPool(ordering)
+		for _, job := range jobs {
+			p.schedule(job)
+		}
+		got := make([]string, 0, len(jobs))
+		for range jobs {
+			got = append(got, p.next().ID)
+		}
+		assert.Equal(t, expected, got, ordering)
+	}
+}
+
+func Test_pool_scheduleDelayed(t *testing.T) {
+	p := newPool(OrderingFIFO)
+	p.schedule(&Job{ID: "delayed", NextAttemptAt: time.Now().Add(50 * time.Millisecond)})
+	p.schedule(&Job{ID: "ready"})
+
+	assert.Equal(t, "ready", p.next().ID)
+	assert.Equal(t, "delayed", p.next().ID)
+}
@@ -0,0 +1,105 @@
+package upload
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ayumi-otosaka-314/brec-pp/config"
+)
+
+const (
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = 30 * time.Second
+	defaultMaxBackoff     = 30 * time.Minute
+)
+
+// RetryPolicy decides whether and when a failed upload job should be attempted again.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter is the ratio of backoff to be randomized, in range of [0, 1].
+	Jitter float64
+}
+
+// NewRetryPolicy creates RetryPolicy from configuration, using defaults for fields not configured.
+func NewRetryPolicy(conf config.Retry) *RetryPolicy {
+	p := &RetryPolicy{
+		MaxAttempts:    int(conf.MaxAttempts),
+		InitialBackoff: conf.InitialBackoff,
+		MaxBackoff:     conf.MaxBackoff,
+		Jitter:         conf.Jitter,
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultMaxAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = defaultInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultMaxBackoff
+	}
+	return p
+}
+
+// ShouldRetry reports whether another attempt should be made after the failed attempts.
+func (p *RetryPolicy) ShouldRetry(attempts int, err error) bool {
+	return attempts < p.MaxAttempts && !IsPermanent(err)
+}
+
+// Backoff returns the duration to wait before the next attempt,
+// doubling for each failed attempt until reaching MaxBackoff.
+func (p *RetryPolicy) Backoff(attempts int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempts && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		backoff += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(backoff))
+	}
+	return backoff
+}
+
+// Attempt is the record of a failed attempt of an upload job.
+type Attempt struct {
+	StartedAt time.Time `json:"startedAt"`
+	Error     string    `json:"error"`
+}
+
+func formatAttempts(attempts []Attempt) string {
+	lines := make([]string, 0, len(attempts))
+	for i, attempt := range attempts {
+		lines = append(lines, fmt.Sprintf(
+			"#%d at %s: %s", i+1, attempt.StartedAt.Format(time.RFC3339), attempt.Error,
+		))
+	}
+	return strings.Join(lines, "\n")
+}
+
+type permanentError struct {
+	error
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.error
+}
+
+// Permanent marks err as not retryable.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
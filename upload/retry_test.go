@@ -0,0 +1,39 @@
+package upload
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ayumi-otosaka-314/brec-pp/config"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := NewRetryPolicy(config.Retry{
+		InitialBackoff: time.Second,
+		MaxBackoff:     10 * time.Second,
+	})
+	assert.Equal(t, time.Second, p.Backoff(1))
+	assert.Equal(t, 2*time.Second, p.Backoff(2))
+	assert.Equal(t, 8*time.Second, p.Backoff(4))
+	assert.Equal(t, 10*time.Second, p.Backoff(5))
+	assert.Equal(t, 10*time.Second, p.Backoff(100))
+
+	p.Jitter = 0.5
+	for range 10 {
+		backoff := p.Backoff(2)
+		assert.GreaterOrEqual(t, backoff, time.Second)
+		assert.LessOrEqual(t, backoff, 3*time.Second)
+	}
+}
+
+func TestRetryPolicy_ShouldRetry(t *testing.T) {
+	p := NewRetryPolicy(config.Retry{MaxAttempts: 3})
+	err := errors.New("test error")
+	assert.True(t, p.ShouldRetry(1, err))
+	assert.True(t, p.ShouldRetry(2, errors.Wrap(err, "wrapped")))
+	assert.False(t, p.ShouldRetry(3, err))
+	assert.False(t, p.ShouldRetry(1, errors.Wrap(Permanent(err), "wrapped")))
+}
@@ -0,0 +1,200 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/notification"
+)
+
+type service struct {
+	logger   *zap.Logger
+	queue    *Queue
+	uploader Uploader
+	timeout  time.Duration
+	retry    *RetryPolicy
+	notifier notification.Service
+	pool     *pool
+
+	// ctx is cancelled on Close, to cancel uploads in progress.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+
+	// mu guards check-and-enqueue of submitted jobs.
+	mu sync.Mutex
+}
+
+// NewService creates an upload.Service backed by the persistent queue,
+// uploading with at most concurrency jobs at the same time.
+// Unfinished jobs in the queue are resumed on creation.
+func NewService(
+	logger *zap.Logger,
+	queue *Queue,
+	uploader Uploader,
+	timeout time.Duration,
+	retry *RetryPolicy,
+	concurrency int,
+	ordering Ordering,
+	notifier notification.Service,
+) Service {
+	ctx, cancel := context.WithCancel(context.Background())
+	svc := &service{
+		logger:   logger,
+		queue:    queue,
+		uploader: uploader,
+		timeout:  timeout,
+		retry:    retry,
+		notifier: notifier,
+		pool:     newPool(ordering),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	svc.resume()
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	svc.workers.Add(concurrency)
+	for range concurrency {
+		go svc.work()
+	}
+	return svc
+}
+
+func (s *service) Close() {
+	s.cancel()
+	s.pool.close()
+	s.workers.Wait()
+}
+
+// Submit enqueues the job persistently before scheduling it.
+// The recording submitted already is not scheduled again, unless its upload has failed.
+func (s *service) Submit(eventData *brec.EventDataFileClose) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, found, err := s.queue.Get(eventData.RelativePath)
+	if err != nil {
+		return errors.Wrap(err, "error loading upload job")
+	}
+	if found && existing.State != JobStateFailed && sameRecording(existing.Event, eventData) {
+		s.logger.Debug("upload job submitted already", zap.String("filePath", eventData.RelativePath))
+		return nil
+	}
+
+	job, err := s.queue.Enqueue(eventData)
+	if err != nil {
+		return errors.Wrap(err, "error persisting upload job")
+	}
+	s.pool.schedule(job)
+	return nil
+}
+
+func (s *service) resume() {
+	jobs, err := s.queue.Unfinished()
+	if err != nil {
+		s.logger.Error("error loading unfinished upload jobs", zap.Error(err))
+	}
+	for _, job := range jobs {
+		s.logger.Info("resuming upload job",
+			zap.String("filePath", job.ID), zap.String("state", string(job.State)))
+		s.pool.schedule(job)
+	}
+}
+
+func (s *service) work() {
+	defer s.workers.Done()
+	for job := s.pool.next(); job != nil; job = s.pool.next() {
+		s.run(job)
+	}
+}
+
+// run attempts the job once, and schedules it again if it should be retried.
+// Attempts cancelled by Close are not recorded, leaving the job in progress to be resumed.
+func (s *service) run(job *Job) {
+	startedAt := time.Now()
+	err := s.attempt(job)
+	if err == nil || s.ctx.Err() != nil {
+		return
+	}
+	job.Attempts = append(job.Attempts, Attempt{StartedAt: startedAt, Error: err.Error()})
+
+	if !s.retry.ShouldRetry(len(job.Attempts), err) {
+		s.updateState(job, JobStateFailed, err)
+		s.logger.Error("error uploading file", zap.Error(err),
+			zap.String("streamerName", job.Event.StreamerName), zap.String("filePath", job.Event.RelativePath),
+			zap.Int("attempts", len(job.Attempts)), zap.Bool("permanent", IsPermanent(err)))
+		s.notifier.Alert(context.Background(), fmt.Sprintf(
+			"error uploading file [%s] for streamer [%s] after [%d] attempts\n%s",
+			job.Event.RelativePath, job.Event.StreamerName, len(job.Attempts), formatAttempts(job.Attempts),
+		), err)
+		if notifier, ok := s.notifier.(jobNotifier); ok {
+			notifier.OnJobFailed(context.Background(), job, err)
+		}
+		return
+	}
+
+	backoff := s.retry.Backoff(len(job.Attempts))
+	job.NextAttemptAt = time.Now().Add(backoff)
+	s.updateState(job, JobStatePending, err)
+	s.logger.Warn("error uploading file; retrying", zap.Error(err),
+		zap.String("filePath", job.Event.RelativePath),
+		zap.Int("attempts", len(job.Attempts)), zap.Duration("backoff", backoff))
+	s.pool.schedule(job)
+}
+
+func (s *service) attempt(job *Job) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.timeout)
+	defer cancel()
+
+	s.updateState(job, JobStateInProgress, nil)
+	start := time.Now()
+	if err := s.uploader.Upload(ctx, job, s.checkpoint(job)); err != nil {
+		return err
+	}
+	if verifier, ok := s.uploader.(Verifier); ok {
+		if err := verifier.Verify(ctx, job); err != nil {
+			return errors.Wrap(err, "error verifying uploaded file")
+		}
+		job.Verified = true
+	}
+	s.updateState(job, JobStateDone, nil)
+
+	if err := s.notifyDone(ctx, job, time.Since(start)); err != nil {
+		s.logger.Warn("error notifying on upload complete", zap.Error(err))
+	}
+	return nil
+}
+
+func (s *service) notifyDone(ctx context.Context, job *Job, uploadDuration time.Duration) error {
+	if notifier, ok := s.notifier.(jobNotifier); ok {
+		return notifier.OnJobDone(ctx, time.Now(), job, uploadDuration)
+	}
+	return s.notifier.OnUploadComplete(ctx, time.Now(), job.Event, uploadDuration)
+}
+
+func (s *service) checkpoint(job *Job) Checkpoint {
+	return func(resumeToken string, committedBytes uint64) error {
+		job.ResumeToken = resumeToken
+		job.CommittedBytes = committedBytes
+		return s.queue.Update(job)
+	}
+}
+
+func (s *service) updateState(job *Job, jobState JobState, err error) {
+	job.State = jobState
+	job.LastError = ""
+	if err != nil {
+		job.LastError = err.Error()
+	}
+	if err := s.queue.Update(job); err != nil {
+		s.logger.Error("error persisting upload job state", zap.Error(err),
+			zap.String("filePath", job.ID), zap.String("state", string(jobState)))
+	}
+}
@@ -0,0 +1,56 @@
+package upload
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ayumi-otosaka-314/brec-pp/brec"
+	"github.com/ayumi-otosaka-314/brec-pp/config"
+	"github.com/ayumi-otosaka-314/brec-pp/state"
+)
+
+func Test_service_Submit(t *testing.T) {
+	store, err := state.Open(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	uploader := &blockingUploader{started: make(chan string, 2), release: make(chan struct{})}
+	defer close(uploader.release)
+	queue := NewQueue(store, "test", time.Hour)
+	svc := NewService(zaptest.NewLogger(t), queue, uploader, time.Minute, NewRetryPolicy(config.Retry{}), 1,
+		OrderingFIFO, &fakeNotifier{completed: make(chan time.Duration, 1)})
+	defer svc.Close()
+
+	eventData := &brec.EventDataFileClose{RelativePath: "room/test.flv", FileCloseTime: "t1"}
+	require.NoError(t, svc.Submit(eventData))
+	job, found, err := queue.Get(eventData.RelativePath)
+	require.NoError(t, err)
+	require.True(t, found, "job should be persisted once submitted")
+	assert.Equal(t, eventData.RelativePath, <-uploader.started)
+
+	require.NoError(t, svc.Submit(eventData))
+	resubmitted, _, err := queue.Get(eventData.RelativePath)
+	require.NoError(t, err)
+	assert.Equal(t, job.CreatedAt, resubmitted.CreatedAt, "recording submitted already should not be enqueued again")
+}
+
+// blockingUploader reports uploads started, and blocks them until released.
+type blockingUploader struct {
+	started chan string
+	release chan struct{}
+}
+
+func (u *blockingUploader) Upload(ctx context.Context, job *Job, _ Checkpoint) error {
+	u.started <- job.ID
+	select {
+	case <-u.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}